@@ -0,0 +1,6 @@
+// Package app is what App Engine builds.  The handlers themselves are
+// registered by package westspy so that cmd/westspy can serve them
+// outside App Engine too.
+package app
+
+import _ "westspy"
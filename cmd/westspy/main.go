@@ -0,0 +1,149 @@
+// Command westspy serves the site on a plain net/http server, standing
+// in for App Engine with an in-memory cache and queue and records
+// kept on local disk.
+//
+// Run it from the app directory:
+//
+//	westspy -addr :8080 -data data
+//
+// /admin/ and /cron/ are only served to loopback clients unless -admin
+// gives a user:password for basic authentication.  /_ah/ (incoming
+// mail and warmup) is only ever served to loopback clients.
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+
+	"house"
+	"platform"
+	_ "westspy"
+)
+
+var (
+	addr      = flag.String("addr", ":8080", "Address to listen on")
+	dataDir   = flag.String("data", "data", "Where to keep records, history and mail")
+	host      = flag.String("host", "", "Host name the site is served as (default localhost and the -addr port)")
+	adminAuth = flag.String("admin", "", "user:password required for /admin/ and /cron/")
+	cronFile  = flag.String("cron", "cron.yaml", "Cron definitions to run")
+)
+
+var dustinStatic = regexp.MustCompile(`^/~dustin/(.*\.(css|js|png|pub))$`)
+
+func serveFile(fn string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, fn)
+	}
+}
+
+func isLoopback(r *http.Request) bool {
+	h, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		h = r.RemoteAddr
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackOnly stands in for App Engine keeping /_ah/ internal.
+func loopbackOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopback(r) {
+			http.Error(w, "Forbidden", 403)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly stands in for App Engine's login: admin.
+func adminOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *adminAuth == "" {
+			if !isLoopback(r) {
+				http.Error(w, "Forbidden", 403)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u+":"+p), []byte(*adminAuth)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="westspy"`)
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// routes mirrors the handlers in app.yaml.
+func routes(app http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	mux.HandleFunc("/favicon.ico", serveFile("favicon.ico"))
+	mux.HandleFunc("/robots.txt", serveFile("static/robots.txt"))
+	mux.HandleFunc("/.well-known/keybase.txt", serveFile("static/keybase.txt"))
+	mux.Handle("/cron/", adminOnly(app))
+	mux.Handle("/admin/", adminOnly(app))
+	mux.Handle("/_ah/", loopbackOnly(app))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.ServeFile(w, r, "static/index.html")
+			return
+		}
+		if m := dustinStatic.FindStringSubmatch(r.URL.Path); m != nil {
+			http.ServeFile(w, r, filepath.Join("static/dustin", filepath.FromSlash(m[1])))
+			return
+		}
+		app.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+func main() {
+	flag.Parse()
+
+	if *host == "" {
+		_, port, err := net.SplitHostPort(*addr)
+		if err != nil {
+			log.Fatalf("Invalid address %q: %v", *addr, err)
+		}
+		*host = "localhost:" + port
+	}
+
+	data, err := filepath.Abs(*dataDir)
+	if err != nil {
+		log.Fatalf("Error finding %v: %v", *dataDir, err)
+	}
+
+	platform.Use(platform.Local(data, *host, http.DefaultServeMux))
+	house.SetHistoryStore(house.NewFileHistory(filepath.Join(data, "history")))
+
+	h := routes(http.DefaultServeMux)
+
+	if *cronFile != "" {
+		src, err := ioutil.ReadFile(*cronFile)
+		if err != nil {
+			log.Fatalf("Error reading cron definitions: %v", err)
+		}
+		jobs, err := platform.ParseCron(string(src))
+		if err != nil {
+			log.Fatalf("Error parsing %v: %v", *cronFile, err)
+		}
+		for _, j := range jobs {
+			go j.Run(h)
+		}
+	}
+
+	go platform.LocalRequest(h, "GET", "/_ah/warmup", nil)
+
+	log.Printf("Serving %v on %v with data in %v", *host, *addr, data)
+	log.Fatal(http.ListenAndServe(*addr, h))
+}
@@ -8,8 +8,7 @@ import (
 
 	"golang.org/x/net/context"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/urlfetch"
+	"platform"
 
 	"github.com/dustin/httputil"
 	"kylelemons.net/go/atom"
@@ -38,7 +37,7 @@ func getGithub() []template.HTML {
 }
 
 func fetchFeed(c context.Context, url string) (*atom.Feed, error) {
-	client := urlfetch.Client(c)
+	client := platform.HTTPClient(c)
 
 	res, err := client.Get(url)
 	if err != nil {
@@ -93,7 +92,7 @@ func updateBlog(c context.Context) (*atom.Feed, error) {
 
 // UpdateFeeds updates all monitored feeds immediately.
 func UpdateFeeds(w http.ResponseWriter, req *http.Request) {
-	err := updateFeeds(appengine.NewContext(req))
+	err := updateFeeds(platform.NewContext(req))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
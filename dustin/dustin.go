@@ -10,8 +10,8 @@ import (
 	"strings"
 	"sync"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"platform"
+	"platform/log"
 )
 
 const (
@@ -20,10 +20,18 @@ const (
 )
 
 var (
-	templates  = template.Must(loadTemplates())
-	updateOnce sync.Once
+	templates     *template.Template
+	templatesOnce sync.Once
+	updateOnce    sync.Once
 )
 
+func getTemplates() *template.Template {
+	templatesOnce.Do(func() {
+		templates = template.Must(loadTemplates())
+	})
+	return templates
+}
+
 func loadTemplates() (*template.Template, error) {
 	rv := template.New("").Funcs(template.FuncMap{
 		"limit": func(limit int, s interface{}) interface{} {
@@ -36,6 +44,9 @@ func loadTemplates() (*template.Template, error) {
 	})
 
 	err := filepath.Walk(tmplBase, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() || !strings.HasSuffix(path, ".html") {
 			return nil
 		}
@@ -55,7 +66,7 @@ func loadTemplates() (*template.Template, error) {
 
 // ServePage serves a ~dustin/ page.
 func ServePage(w http.ResponseWriter, req *http.Request) {
-	c := appengine.NewContext(req)
+	c := platform.NewContext(req)
 
 	updateOnce.Do(func() {
 		if !(getGithub() == nil && getBlog() == nil) {
@@ -77,7 +88,7 @@ func ServePage(w http.ResponseWriter, req *http.Request) {
 	}
 	log.Infof(c, "Serving %v", page)
 
-	err := templates.ExecuteTemplate(w, page, struct {
+	err := getTemplates().ExecuteTemplate(w, page, struct {
 		Github interface{}
 		Blog   interface{}
 	}{getGithub(), getBlog()})
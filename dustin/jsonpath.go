@@ -0,0 +1,68 @@
+package dustin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath evaluates a small subset of JSONPath against a decoded JSON
+// document: a leading $ followed by .field, ['field'] and [index]
+// steps.
+func jsonPath(doc interface{}, path string) (interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	cur := doc
+	for p != "" {
+		var step string
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			step, p = p[:end], p[end:]
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v: %q is not an object", path, step)
+			}
+			if cur, ok = m[step]; !ok {
+				return nil, fmt.Errorf("%v: no field %q", path, step)
+			}
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%v: unterminated [", path)
+			}
+			step, p = p[1:end], p[end+1:]
+			if len(step) >= 2 && (step[0] == '\'' || step[0] == '"') && step[len(step)-1] == step[0] {
+				m, ok := cur.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%v: %v is not an object", path, step)
+				}
+				if cur, ok = m[step[1:len(step)-1]]; !ok {
+					return nil, fmt.Errorf("%v: no field %v", path, step)
+				}
+				continue
+			}
+			i, err := strconv.Atoi(step)
+			if err != nil {
+				return nil, fmt.Errorf("%v: bad index %q", path, step)
+			}
+			a, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v: [%v] is not an array", path, step)
+			}
+			if i < 0 {
+				i += len(a)
+			}
+			if i < 0 || i >= len(a) {
+				return nil, fmt.Errorf("%v: index %v out of range", path, step)
+			}
+			cur = a[i]
+		default:
+			return nil, fmt.Errorf("%v: unexpected %q", path, p[0])
+		}
+	}
+	return cur, nil
+}
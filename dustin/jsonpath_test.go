@@ -0,0 +1,64 @@
+package dustin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testDoc = `{
+  "name": "attic",
+  "readings": [
+    {"value": 21.5, "time": "2026-10-01T12:00:00Z"},
+    {"value": 22, "time": "2026-10-01T12:05:00Z"}
+  ],
+  "meta": {"odd.key": {"ok": true}, "nothing": null}
+}`
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	d := json.NewDecoder(strings.NewReader(testDoc))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want, err string
+	}{
+		{"$.name", "attic", ""},
+		{" $.readings[0].value ", "21.5", ""},
+		{"$.readings[1].time", "2026-10-01T12:05:00Z", ""},
+		{"$.readings[-1].value", "22", ""},
+		{"$['meta']['odd.key'].ok", "true", ""},
+		{`$.meta["odd.key"]["ok"]`, "true", ""},
+		{"$.meta.nothing", "<nil>", ""},
+		{"$", "map[", ""},
+
+		{"$.missing", "", `no field "missing"`},
+		{"$.meta['missing']", "", "no field 'missing'"},
+		{"$.name.first", "", `"first" is not an object`},
+		{"$.name['first']", "", "'first' is not an object"},
+		{"$.readings[2]", "", "index 2 out of range"},
+		{"$.readings[-3]", "", "index -3 out of range"},
+		{"$.readings[x]", "", `bad index "x"`},
+		{"$.readings[0", "", "unterminated ["},
+		{"$.name[0]", "", "[0] is not an array"},
+		{"$readings", "", "unexpected 'r'"},
+		{"name", "", "unexpected 'n'"},
+	}
+	for _, test := range tests {
+		v, err := jsonPath(doc, test.path)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got %v, %v, want error containing %q", test.path, v, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %v", test.path, err)
+		case !strings.HasPrefix(fmt.Sprint(v), test.want):
+			t.Errorf("%q: got %v, want %v", test.path, v, test.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package dustin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+const sensorConfigFile = "sensors.json"
+
+// duration is a time.Duration that reads as a string (e.g. "1h") from
+// JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	x, err := time.ParseDuration(s)
+	*d = duration(x)
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+// A sensorFeed is a sensor we expect to hear from.
+type sensorFeed struct {
+	Key string `json:"key"`
+	// Where to look for it: adafruit (the default), json or house.
+	Source string `json:"source,omitempty"`
+	// For json sources, the document to fetch and where to find
+	// the value and its timestamp in it.  TimeFormat is unix,
+	// unixms or a Go time layout (RFC3339 by default).
+	URL        string `json:"url,omitempty"`
+	ValuePath  string `json:"valuePath,omitempty"`
+	TimePath   string `json:"timePath,omitempty"`
+	TimeFormat string `json:"timeFormat,omitempty"`
+	// How old the last datum may get before we complain.
+	MaxAge duration `json:"maxAge,omitempty"`
+	// Past this age we give up complaining.
+	MaxErrAge duration `json:"maxErrAge,omitempty"`
+	// How often to repeat an alert while a feed stays stale.  Zero
+	// means alert once.
+	Renotify duration `json:"renotify,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	// Where complaints go.  See notify.
+	Notify string `json:"notify,omitempty"`
+}
+
+// sensorConfig is everything the sensor monitor needs to know.  Feed
+// fields left empty take the defaults given here.
+type sensorConfig struct {
+	User      string       `json:"user"`
+	MaxAge    duration     `json:"maxAge"`
+	MaxErrAge duration     `json:"maxErrAge"`
+	Renotify  duration     `json:"renotify"`
+	Severity  string       `json:"severity"`
+	Notify    string       `json:"notify"`
+	Feeds     []sensorFeed `json:"feeds"`
+}
+
+// storedConfig is a config document uploaded from the admin page.
+type storedConfig struct {
+	Data    []byte `datastore:",noindex"`
+	Updated time.Time
+}
+
+func parseSensorConfig(data []byte) (*sensorConfig, error) {
+	sc := &sensorConfig{}
+	if err := json.Unmarshal(data, sc); err != nil {
+		return nil, err
+	}
+	if sc.User == "" {
+		return nil, fmt.Errorf("no user configured")
+	}
+
+	seen := map[string]bool{}
+	for i := range sc.Feeds {
+		f := &sc.Feeds[i]
+		if f.Key == "" {
+			return nil, fmt.Errorf("feed %d has no key", i)
+		}
+		if seen[f.Key] {
+			return nil, fmt.Errorf("feed %v is listed twice", f.Key)
+		}
+		seen[f.Key] = true
+
+		switch f.Source {
+		case "", sourceAdafruit, sourceHouse:
+		case sourceJSON:
+			if f.URL == "" || f.ValuePath == "" || f.TimePath == "" {
+				return nil, fmt.Errorf("json feed %v needs url, valuePath and timePath", f.Key)
+			}
+		default:
+			return nil, fmt.Errorf("feed %v has unknown source %q", f.Key, f.Source)
+		}
+
+		if f.MaxAge == 0 {
+			f.MaxAge = sc.MaxAge
+		}
+		if f.MaxErrAge == 0 {
+			f.MaxErrAge = sc.MaxErrAge
+		}
+		if f.Renotify == 0 {
+			f.Renotify = sc.Renotify
+		}
+		if f.Severity == "" {
+			f.Severity = sc.Severity
+		}
+		if f.Notify == "" {
+			f.Notify = sc.Notify
+		}
+		if f.MaxAge <= 0 || f.MaxErrAge < f.MaxAge {
+			return nil, fmt.Errorf("feed %v needs 0 < maxAge <= maxErrAge", f.Key)
+		}
+	}
+	return sc, nil
+}
+
+// rawSensorConfig returns the config document in effect: whatever was
+// last uploaded, or the one that shipped with the app.
+func rawSensorConfig(c context.Context) ([]byte, error) {
+	stored := storedConfig{}
+	err := platform.Default.Blobs.Get(c, "Config", "sensors", &stored)
+	switch err {
+	case nil:
+		return stored.Data, nil
+	case platform.ErrNoSuchEntity:
+		return ioutil.ReadFile(sensorConfigFile)
+	}
+	return nil, err
+}
+
+func loadSensorConfig(c context.Context) (*sensorConfig, error) {
+	data, err := rawSensorConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return parseSensorConfig(data)
+}
+
+// storeSensorConfig validates and saves a new config document.
+func storeSensorConfig(c context.Context, data []byte) error {
+	if _, err := parseSensorConfig(data); err != nil {
+		return err
+	}
+	return platform.Default.Blobs.Put(c, "Config", "sensors", &storedConfig{data, time.Now()})
+}
@@ -0,0 +1,91 @@
+package dustin
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSensorConfigDefaults(t *testing.T) {
+	sc, err := parseSensorConfig([]byte(`{
+	  "user": "someone",
+	  "maxAge": "1h",
+	  "maxErrAge": "2h",
+	  "renotify": "4h",
+	  "severity": "warning",
+	  "notify": "errors",
+	  "feeds": [
+	    {"key": "a"},
+	    {"key": "b", "maxAge": "10m", "maxErrAge": "30m", "renotify": "0s",
+	     "severity": "critical", "notify": "mailto:x@example.com"}
+	  ]
+	}`))
+	if err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+
+	tests := []struct {
+		f                         sensorFeed
+		maxAge, maxErrAge, renote time.Duration
+		severity, notify          string
+	}{
+		{sc.Feeds[0], time.Hour, 2 * time.Hour, 4 * time.Hour, "warning", "errors"},
+		// An explicit zero renotify still takes the default.
+		{sc.Feeds[1], 10 * time.Minute, 30 * time.Minute, 4 * time.Hour,
+			"critical", "mailto:x@example.com"},
+	}
+	for _, test := range tests {
+		f := test.f
+		if time.Duration(f.MaxAge) != test.maxAge || time.Duration(f.MaxErrAge) != test.maxErrAge ||
+			time.Duration(f.Renotify) != test.renote || f.Severity != test.severity ||
+			f.Notify != test.notify {
+			t.Errorf("%v: got %+v, want %+v", f.Key, f, test)
+		}
+		if src := sc.source(f); src != (adafruitSource{"someone"}) {
+			t.Errorf("%v: expected the adafruit source, got %#v", f.Key, src)
+		}
+	}
+}
+
+func TestSensorConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, config, err string
+	}{
+		{"bad json", `{"user": `, "unexpected end"},
+		{"bad duration", `{"user": "u", "maxAge": "soon"}`, "invalid duration"},
+		{"no user", `{"maxAge": "1h", "maxErrAge": "2h"}`, "no user"},
+		{"no key", `{"user": "u", "maxAge": "1h", "maxErrAge": "2h", "feeds": [{}]}`,
+			"feed 0 has no key"},
+		{"duplicate", `{"user": "u", "maxAge": "1h", "maxErrAge": "2h",
+		  "feeds": [{"key": "a"}, {"key": "a"}]}`, "listed twice"},
+		{"unknown source", `{"user": "u", "maxAge": "1h", "maxErrAge": "2h",
+		  "feeds": [{"key": "a", "source": "carrier-pigeon"}]}`, "unknown source"},
+		{"json without paths", `{"user": "u", "maxAge": "1h", "maxErrAge": "2h",
+		  "feeds": [{"key": "a", "source": "json", "url": "http://x/"}]}`,
+			"needs url, valuePath and timePath"},
+		{"no max age", `{"user": "u", "feeds": [{"key": "a"}]}`, "0 < maxAge"},
+		{"err age too short", `{"user": "u", "maxAge": "1h", "maxErrAge": "30m",
+		  "feeds": [{"key": "a"}]}`, "0 < maxAge <= maxErrAge"},
+	}
+	for _, test := range tests {
+		sc, err := parseSensorConfig([]byte(test.config))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got %+v, %v, want error containing %q", test.name, sc, err, test.err)
+		}
+	}
+}
+
+func TestShippedSensorConfig(t *testing.T) {
+	data, err := ioutil.ReadFile("../" + sensorConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := parseSensorConfig(data)
+	if err != nil {
+		t.Fatalf("Error parsing the shipped config: %v", err)
+	}
+	if len(sc.Feeds) == 0 {
+		t.Errorf("Expected some feeds in the shipped config")
+	}
+}
@@ -1,96 +1,339 @@
 package dustin
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"encoding/json"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/dustin/httputil"
+	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
+	"platform"
+	"platform/log"
 )
 
-func CheckSensors(w http.ResponseWriter, req *http.Request) {
-	c := appengine.NewContext(req)
+const (
+	aioBase     = "https://io.adafruit.com/api/v2/"
+	alertSender = "westspy@west-spy.appspotmail.com"
+	adminTmpl   = "templates/admin/sensors.html"
+)
 
-	hc := urlfetch.Client(c)
+var (
+	adminTemplates     *template.Template
+	adminTemplatesOnce sync.Once
+)
 
-	careabout := map[string]bool{"oroville.workshop-temp": true,
-		"oroville.workshop-humidity": true,
-		"sj.attic-temp":              true,
-		"sj.plant-temp":              true,
-		"sj.plant-soil":              true,
+// sensorState is what the monitor remembers about a feed.
+type sensorState struct {
+	Key         string
+	LastSeen    time.Time
+	LastValue   string `datastore:",noindex"`
+	LastChecked time.Time
+	LastAlerted time.Time
+	LastError   string `datastore:",noindex"`
+	// Whether the feed is currently stale, and the time of the
+	// last datum before it went quiet.
+	Stale      bool
+	QuietSince time.Time
+}
+
+func sensorStateNames(feeds []sensorFeed) []string {
+	names := make([]string, 0, len(feeds))
+	for _, f := range feeds {
+		names = append(names, f.Key)
 	}
+	return names
+}
 
+func loadSensorStates(c context.Context, feeds []sensorFeed) ([]*sensorState, error) {
+	rv := make([]*sensorState, len(feeds))
+	for i, f := range feeds {
+		rv[i] = &sensorState{Key: f.Key}
+	}
+	err := platform.Default.Blobs.GetMulti(c, "SensorState", sensorStateNames(feeds), rv)
+	if me, ok := err.(platform.MultiError); ok {
+		for _, e := range me {
+			if e != nil && e != platform.ErrNoSuchEntity {
+				return nil, err
+			}
+		}
+		err = nil
+	}
+	return rv, err
+}
+
+func saveSensorStates(c context.Context, feeds []sensorFeed, states []*sensorState) error {
+	return platform.Default.Blobs.PutMulti(c, "SensorState", sensorStateNames(feeds), states)
+}
+
+func aioRequest(method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-AIO-Key", os.Getenv("ADAFRUIT_IO_KEY"))
+	return req, nil
+}
+
+// lastDatum fetches the most recent value and time for a feed.
+func lastDatum(c context.Context, hc *http.Client, user, feed string) (string, time.Time, error) {
 	const timefmt = "2006-01-02T15:04:05Z"
-	const maxAge = time.Hour
-	const maxErrAge = 2 * time.Hour
 
-	g := errgroup.Group{}
-	for k := range careabout {
-		k := k
-		g.Go(func() error {
-			durl := "https://io.adafruit.com/api/v2/dlsspy/feeds/" + k + "/data/last"
-			req, err := http.NewRequest("GET", durl, nil)
-			if err != nil {
-				return err
-			}
-			req.Header.Set("X-AIO-Key", os.Getenv("ADAFRUIT_IO_KEY"))
-			res, err := hc.Do(req)
-			if err != nil {
-				return err
-			}
-			defer res.Body.Close()
-
-			thing := struct {
-				Value string
-				TS    string `json:"created_at"`
-			}{}
-			if err := json.NewDecoder(res.Body).Decode(&thing); err != nil {
-				return err
-			}
-			log.Debugf(c, "parsed response for %v as %#v", k, thing)
+	req, err := aioRequest("GET", aioBase+user+"/feeds/"+feed+"/data/last", nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", time.Time{}, httputil.HTTPError(res)
+	}
 
-			ts, err := time.Parse(timefmt, thing.TS)
-			if err != nil {
-				return err
-			}
+	thing := struct {
+		Value string
+		TS    string `json:"created_at"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&thing); err != nil {
+		return "", time.Time{}, err
+	}
+	log.Debugf(c, "parsed response for %v as %#v", feed, thing)
 
-			if time.Since(ts) > maxErrAge {
-				log.Debugf(c, "too old to even report: %#v: %v", thing, time.Since(ts))
-			} else if time.Since(ts) > maxAge {
-				log.Infof(c, "too old:  %#v: %v", thing, time.Since(ts))
+	ts, err := time.Parse(timefmt, thing.TS)
+	return thing.Value, ts, err
+}
 
-				msg := k + " last heard " + humanize.RelTime(ts, time.Now(), "ago", "since")
-				form := url.Values{"value": {msg}}
+// notify sends a message to a target, which is one of:
+//
+//	mailto:someone@example.com  -- email
+//	http(s)://...               -- a JSON POST
+//	anything else               -- the name of an Adafruit IO feed
+func notify(c context.Context, hc *http.Client, user, target string, f sensorFeed, msg string) error {
+	switch {
+	case strings.HasPrefix(target, "mailto:"):
+		return platform.Default.Mail.Send(c, &platform.Message{
+			Sender:  alertSender,
+			To:      []string{target[len("mailto:"):]},
+			Subject: "[" + f.Severity + "] " + msg,
+			Body:    msg + "\n",
+		})
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		data, err := json.Marshal(map[string]string{
+			"feed":     f.Key,
+			"severity": f.Severity,
+			"message":  msg,
+		})
+		if err != nil {
+			return err
+		}
+		res, err := hc.Post(target, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode >= 300 {
+			return httputil.HTTPError(res)
+		}
+		return nil
+	}
 
-				// POST /{username}/feeds/{feed_key}/data
-				durl := "https://io.adafruit.com/api/v2/dlsspy/feeds/errors/data"
-				req, err := http.NewRequest("POST", durl, strings.NewReader(form.Encode()))
-				if err != nil {
-					return err
-				}
-				req.Header.Set("X-AIO-Key", os.Getenv("ADAFRUIT_IO_KEY"))
-				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-				if res, err := hc.Do(req); err != nil || res.StatusCode != 200 {
-					log.Errorf(c, "error posting %s to %v -- %v:\n%v",
-						form, durl, err, httputil.HTTPError(res))
-				}
-			}
-			log.Debugf(c, "age of %#v from %v to %v is %v", thing, ts, time.Now(), time.Since(ts))
+	form := url.Values{"value": {msg}}
+
+	// POST /{username}/feeds/{feed_key}/data
+	req, err := aioRequest("POST", aioBase+user+"/feeds/"+target+"/data",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return httputil.HTTPError(res)
+	}
+	return nil
+}
+
+// observe records the latest datum for a feed and decides whether
+// anybody needs to hear about it.  Alerts go out once when a feed goes
+// stale (and again every Renotify, if set), and a recovery message
+// follows when it comes back.
+func (st *sensorState) observe(f sensorFeed, v string, ts, now time.Time) (msg string, alert bool) {
+	wasStale, quietSince := st.Stale, st.QuietSince
+	alerted := wasStale && st.LastAlerted.After(quietSince)
+	st.LastValue = v
+
+	age := now.Sub(ts)
+	if age <= time.Duration(f.MaxAge) {
+		st.LastSeen = ts
+		st.Stale = false
+		if alerted {
+			return f.Key + " recovered after " +
+				strings.TrimSpace(humanize.RelTime(quietSince, ts, "", "")), false
+		}
+		return "", false
+	}
+
+	if !wasStale {
+		st.Stale = true
+		st.QuietSince = ts
+	}
+	st.LastSeen = ts
+
+	if age > time.Duration(f.MaxErrAge) {
+		return "", false
+	}
+	if !alerted || (f.Renotify > 0 && now.Sub(st.LastAlerted) >= time.Duration(f.Renotify)) {
+		return f.Key + " last heard " + humanize.RelTime(ts, now, "ago", "since"), true
+	}
+	return "", false
+}
+
+// checkSensor looks at one feed and tells somebody if it's gone quiet
+// or come back.
+func checkSensor(c context.Context, hc *http.Client, sc *sensorConfig, f sensorFeed, st *sensorState) error {
+	now := time.Now()
+	st.LastChecked = now
+
+	v, ts, err := sc.source(f).Last(c, hc, f)
+	if err != nil {
+		st.LastError = err.Error()
+		return err
+	}
+	st.LastError = ""
+
+	msg, alert := st.observe(f, v, ts, now)
+	log.Debugf(c, "age of %v from %v to %v is %v", f.Key, ts, now, now.Sub(ts))
+	if msg == "" {
+		return nil
+	}
+
+	log.Infof(c, "%v", msg)
+	if err := notify(c, hc, sc.User, f.Notify, f, msg); err != nil {
+		log.Errorf(c, "error notifying %v about %v: %v", f.Notify, f.Key, err)
+	} else if alert {
+		st.LastAlerted = now
+	}
+
+	return nil
+}
+
+// CheckSensors looks for configured sensors that have gone quiet.
+func CheckSensors(w http.ResponseWriter, req *http.Request) {
+	c := platform.NewContext(req)
+
+	sc, err := loadSensorConfig(c)
+	if err != nil {
+		http.Error(w, "Error loading sensor config: "+err.Error(), 500)
+		return
+	}
+
+	states, err := loadSensorStates(c, sc.Feeds)
+	if err != nil {
+		http.Error(w, "Error loading sensor state: "+err.Error(), 500)
+		return
+	}
+
+	hc := platform.HTTPClient(c)
 
-			return nil
+	g := errgroup.Group{}
+	for i := range sc.Feeds {
+		f, st := sc.Feeds[i], states[i]
+		g.Go(func() error {
+			return checkSensor(c, hc, sc, f, st)
 		})
 	}
+	err = g.Wait()
+
+	if serr := saveSensorStates(c, sc.Feeds, states); serr != nil {
+		log.Errorf(c, "Error saving sensor state: %v", serr)
+	}
 
-	if err := g.Wait(); err != nil {
+	if err != nil {
 		http.Error(w, err.Error(), 500)
+		return
 	}
 	w.WriteHeader(204)
 }
+
+func getAdminTemplates() *template.Template {
+	adminTemplatesOnce.Do(func() {
+		adminTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+			"ago": func(t time.Time) string {
+				if t.IsZero() {
+					return "never"
+				}
+				return humanize.Time(t)
+			},
+		}).ParseFiles(adminTmpl))
+	})
+	return adminTemplates
+}
+
+// SensorStatus shows the sensor monitor's config and what it knows
+// about each feed.  POSTing a config document replaces the config.
+func SensorStatus(w http.ResponseWriter, req *http.Request) {
+	c := platform.NewContext(req)
+
+	var msg string
+	if req.Method == "POST" {
+		data := []byte(req.FormValue("config"))
+		if err := storeSensorConfig(c, data); err != nil {
+			msg = "Config not saved: " + err.Error()
+		} else {
+			http.Redirect(w, req, req.URL.Path, http.StatusFound)
+			return
+		}
+	}
+
+	raw, err := rawSensorConfig(c)
+	if err != nil {
+		http.Error(w, "Error loading sensor config: "+err.Error(), 500)
+		return
+	}
+
+	type feedStatus struct {
+		Feed  sensorFeed
+		State *sensorState
+	}
+	var feeds []feedStatus
+
+	sc, err := parseSensorConfig(raw)
+	if err != nil {
+		msg = fmt.Sprintf("Current config is invalid: %v", err)
+	} else {
+		states, err := loadSensorStates(c, sc.Feeds)
+		if err != nil {
+			http.Error(w, "Error loading sensor state: "+err.Error(), 500)
+			return
+		}
+		for i, f := range sc.Feeds {
+			st := states[i]
+			feeds = append(feeds, feedStatus{f, st})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getAdminTemplates().ExecuteTemplate(w, "sensors.html", struct {
+		Message string
+		Config  string
+		Feeds   []feedStatus
+	}{msg, string(raw), feeds})
+	if err != nil {
+		log.Errorf(c, "Error rendering sensor status: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package dustin
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	base := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	once := sensorFeed{Key: "attic", MaxAge: duration(time.Hour), MaxErrAge: duration(24 * time.Hour)}
+	often := once
+	often.Renotify = duration(2 * time.Hour)
+
+	tests := []struct {
+		name    string
+		f       sensorFeed
+		now, ts time.Duration
+		msg     string
+		alert   bool
+		stale   bool
+	}{
+		{"fresh", once, 0, -10 * time.Minute, "", false, false},
+		{"aging", once, time.Hour, 0, "", false, false},
+		{"stale", once, 90 * time.Minute, 0, "attic last heard 1 hour ago", true, true},
+		{"already told", once, 2 * time.Hour, 0, "", false, true},
+		{"still quiet", once, 5 * time.Hour, 0, "", false, true},
+		{"recovered", once, 6 * time.Hour, 5*time.Hour + 50*time.Minute,
+			"attic recovered after 5 hours", false, false},
+		{"fresh again", once, 7 * time.Hour, 6*time.Hour + 50*time.Minute, "", false, false},
+		// Too old to bother anybody, so no recovery either.
+		{"given up", once, 40 * time.Hour, 6*time.Hour + 50*time.Minute, "", false, true},
+		{"quietly back", once, 41 * time.Hour, 41 * time.Hour, "", false, false},
+
+		{"renotify stale", often, 43 * time.Hour, 41 * time.Hour, "attic last heard 2 hours ago", true, true},
+		{"renotify wait", often, 44 * time.Hour, 41 * time.Hour, "", false, true},
+		{"renotify again", often, 45 * time.Hour, 41 * time.Hour, "attic last heard 4 hours ago", true, true},
+		{"renotify recovered", often, 46 * time.Hour, 46 * time.Hour,
+			"attic recovered after 5 hours", false, false},
+	}
+
+	st := &sensorState{Key: "attic"}
+	for _, test := range tests {
+		now, ts := base.Add(test.now), base.Add(test.ts)
+		msg, alert := st.observe(test.f, "20", ts, now)
+		if !strings.HasPrefix(msg, test.msg) || (msg == "") != (test.msg == "") ||
+			alert != test.alert || st.Stale != test.stale {
+			t.Errorf("%v: got %q, %v, stale=%v, want %q, %v, stale=%v",
+				test.name, msg, alert, st.Stale, test.msg, test.alert, test.stale)
+		}
+		if !st.LastSeen.Equal(ts) {
+			t.Errorf("%v: last seen %v, want %v", test.name, st.LastSeen, ts)
+		}
+		// As checkSensor does once the alert goes out.
+		if alert {
+			st.LastAlerted = now
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dustin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dustin/httputil"
+	"golang.org/x/net/context"
+
+	"house"
+)
+
+// Source names usable in a feed's config.
+const (
+	sourceAdafruit = "adafruit"
+	sourceJSON     = "json"
+	sourceHouse    = "house"
+)
+
+// houseMaxAge is how far back we'll look for a house sensor that's
+// fallen out of cache.
+const houseMaxAge = 30 * 24 * time.Hour
+
+// A SensorSource knows where to find the latest datum for a feed.
+type SensorSource interface {
+	Last(c context.Context, hc *http.Client, f sensorFeed) (value string, ts time.Time, err error)
+}
+
+// adafruitSource reads the last datum of an Adafruit IO feed.
+type adafruitSource struct {
+	user string
+}
+
+func (a adafruitSource) Last(c context.Context, hc *http.Client, f sensorFeed) (string, time.Time, error) {
+	return lastDatum(c, hc, a.user, f.Key)
+}
+
+// jsonSource fetches a JSON document and picks the value and timestamp
+// out of it with the feed's JSONPaths.
+type jsonSource struct{}
+
+func (jsonSource) Last(c context.Context, hc *http.Client, f sensorFeed) (string, time.Time, error) {
+	res, err := hc.Get(f.URL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", time.Time{}, httputil.HTTPError(res)
+	}
+
+	var doc interface{}
+	d := json.NewDecoder(res.Body)
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return "", time.Time{}, err
+	}
+
+	v, err := jsonPath(doc, f.ValuePath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	tv, err := jsonPath(doc, f.TimePath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ts, err := parseJSONTime(tv, f.TimeFormat)
+	return fmt.Sprint(v), ts, err
+}
+
+// parseJSONTime understands "unix" and "unixms" (seconds or
+// milliseconds since the epoch as a number or string) or a Go time
+// layout, defaulting to RFC3339.
+func parseJSONTime(v interface{}, format string) (time.Time, error) {
+	s := fmt.Sprint(v)
+	switch format {
+	case "unix", "unixms":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == "unixms" {
+			f /= 1000
+		}
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+	case "":
+		format = time.RFC3339Nano
+	}
+	return time.Parse(format, s)
+}
+
+// houseSource reads the thermometers posting to /house/input/.  The
+// feed key is the sensor's serial number.
+type houseSource struct{}
+
+func (houseSource) Last(c context.Context, hc *http.Client, f sensorFeed) (string, time.Time, error) {
+	r, err := house.LatestReading(c, f.Key, houseMaxAge)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return strconv.FormatFloat(r.Reading, 'f', -1, 64), r.Timestamp, nil
+}
+
+// source picks the SensorSource for a feed.
+func (sc *sensorConfig) source(f sensorFeed) SensorSource {
+	switch f.Source {
+	case sourceJSON:
+		return jsonSource{}
+	case sourceHouse:
+		return houseSource{}
+	}
+	return adafruitSource{sc.User}
+}
@@ -0,0 +1,38 @@
+package dustin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseJSONTime(t *testing.T) {
+	want := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v      interface{}
+		format string
+		want   time.Time
+		err    bool
+	}{
+		{"2026-10-01T12:00:00Z", "", want, false},
+		{"2026-10-01T05:00:00.25-07:00", "", want.Add(250 * time.Millisecond), false},
+		{json.Number("1790856000"), "unix", want, false},
+		{"1790856000.5", "unix", want.Add(500 * time.Millisecond), false},
+		{1790856000.0, "unix", want, false},
+		{json.Number("1790856000250"), "unixms", want.Add(250 * time.Millisecond), false},
+		{"01 Oct 26 12:00 UTC", time.RFC822, want, false},
+
+		{"yesterday", "", time.Time{}, true},
+		{"2026-10-01", "", time.Time{}, true},
+		{"soon", "unix", time.Time{}, true},
+		{nil, "unixms", time.Time{}, true},
+		{"2026-10-01T12:00:00Z", time.RFC822, time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseJSONTime(test.v, test.format)
+		if (err != nil) != test.err || !got.Equal(test.want) {
+			t.Errorf("%v as %q: got %v, %v, want %v (error: %v)",
+				test.v, test.format, got, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package house
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+const alertSender = "westspy@west-spy.appspotmail.com"
+
+// Alert states.
+const (
+	alertOK      = "ok"
+	alertPending = "pending"
+	alertFiring  = "firing"
+)
+
+// Duration is a time.Duration that reads as a string (e.g. "15m")
+// from JSON.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	x, err := time.ParseDuration(s)
+	*d = Duration(x)
+	return err
+}
+
+// MarshalJSON writes a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// AlertConfig describes when and how to complain about rooms that go
+// outside their Min/Max.
+type AlertConfig struct {
+	// A room must come back this far inside its bounds before an
+	// alert clears.  Rooms may override it.
+	Hysteresis float64
+	// How long a room must be out of bounds before we alert.
+	MinDuration Duration
+	// Where to send alerts.
+	Email    []string
+	Webhooks []string
+	Log      bool
+}
+
+// An Alert is a room going out of (or back into) bounds.
+type Alert struct {
+	Site      string    `json:"site"`
+	Room      string    `json:"room"`
+	Serial    string    `json:"serial"`
+	Kind      string    `json:"kind"`
+	Reading   float64   `json:"reading"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Since     time.Time `json:"since"`
+	At        time.Time `json:"at"`
+	Recovered bool      `json:"recovered"`
+	Text      string    `json:"text"`
+}
+
+// A Notifier delivers alerts somewhere.
+type Notifier interface {
+	Notify(c context.Context, a Alert) error
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(c context.Context, a Alert) error {
+	log.Warningf(c, "House alert: %v", a.Text)
+	return nil
+}
+
+type mailNotifier struct {
+	to []string
+}
+
+func (m mailNotifier) Notify(c context.Context, a Alert) error {
+	return platform.Default.Mail.Send(c, &platform.Message{
+		Sender:  alertSender,
+		To:      m.to,
+		Subject: "[house] " + a.Text,
+		Body: fmt.Sprintf("%v\n\nSite:    %v\nRoom:    %v (%v)\nRange:   %v - %v\nSince:   %v\n",
+			a.Text, a.Site, a.Room, a.Serial, a.Min, a.Max, a.Since.Format(time.RFC1123)),
+	})
+}
+
+type webhookNotifier struct {
+	url string
+}
+
+func (wh webhookNotifier) Notify(c context.Context, a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	res, err := platform.HTTPClient(c).Post(wh.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v: %v", wh.url, res.Status)
+	}
+	return nil
+}
+
+func (ac AlertConfig) notifiers() []Notifier {
+	var rv []Notifier
+	if ac.Log {
+		rv = append(rv, logNotifier{})
+	}
+	if len(ac.Email) > 0 {
+		rv = append(rv, mailNotifier{ac.Email})
+	}
+	for _, u := range ac.Webhooks {
+		rv = append(rv, webhookNotifier{u})
+	}
+	return rv
+}
+
+// alertState is what we remember about a room between batches.
+type alertState struct {
+	Room     string
+	State    string
+	Kind     string
+	Since    time.Time
+	Notified time.Time
+}
+
+func (hc *HouseConfig) hysteresisOf(room *Room) float64 {
+	if room.Hysteresis != 0 {
+		return room.Hysteresis
+	}
+	return hc.Alerts.Hysteresis
+}
+
+// step advances a room's alert state with a new reading, returning an
+// alert if somebody should hear about it.
+func (st *alertState) step(hc *HouseConfig, name string, room *Room, r Reading) *Alert {
+	kind := ""
+	switch {
+	case r.Reading < room.Min:
+		kind = "low"
+	case r.Reading > room.Max:
+		kind = "high"
+	}
+	h := hc.hysteresisOf(room)
+	clear := r.Reading >= room.Min+h && r.Reading <= room.Max-h
+
+	mkAlert := func(recovered bool) *Alert {
+		a := &Alert{
+			Room:      name,
+			Serial:    room.SN,
+			Kind:      st.Kind,
+			Reading:   r.Reading,
+			Min:       room.Min,
+			Max:       room.Max,
+			Since:     st.Since,
+			At:        r.Timestamp,
+			Recovered: recovered,
+		}
+		reading := hc.display(room, r.Reading, "")
+		if recovered {
+			a.Text = fmt.Sprintf("%v is back to %v after %v", name, reading,
+				r.Timestamp.Sub(st.Since))
+		} else {
+			a.Text = fmt.Sprintf("%v is %v: %v", name, st.Kind, reading)
+		}
+		return a
+	}
+
+	switch st.State {
+	case alertFiring:
+		if clear {
+			a := mkAlert(true)
+			*st = alertState{Room: name, State: alertOK, Notified: r.Timestamp}
+			return a
+		}
+		// Going straight from too hot to too cold is news too.
+		if kind != "" && kind != st.Kind {
+			st.Kind, st.Since, st.Notified = kind, r.Timestamp, r.Timestamp
+			return mkAlert(false)
+		}
+	case alertPending:
+		if kind == "" {
+			st.State = alertOK
+			return nil
+		}
+		st.Kind = kind
+		if r.Timestamp.Sub(st.Since) >= time.Duration(hc.Alerts.MinDuration) {
+			st.State = alertFiring
+			st.Notified = r.Timestamp
+			return mkAlert(false)
+		}
+	default:
+		if kind != "" {
+			*st = alertState{Room: name, State: alertPending, Kind: kind, Since: r.Timestamp}
+			return st.step(hc, name, room, r)
+		}
+	}
+	return nil
+}
+
+func loadAlertStates(c context.Context, s *site, names []string) ([]*alertState, error) {
+	rv := make([]*alertState, len(names))
+	for i := range rv {
+		rv[i] = &alertState{Room: names[i], State: alertOK}
+	}
+	err := platform.Default.Blobs.GetMulti(c, "AlertState", s.keys(names), rv)
+	if me, ok := err.(platform.MultiError); ok {
+		for _, e := range me {
+			if e != nil && e != platform.ErrNoSuchEntity {
+				return nil, err
+			}
+		}
+		err = nil
+	}
+	return rv, err
+}
+
+func saveAlertStates(c context.Context, s *site, names []string, states []*alertState) error {
+	return platform.Default.Blobs.PutMulti(c, "AlertState", s.keys(names), states)
+}
+
+// maxAlertStates is how many rooms' states are stepped in one
+// transaction.
+const maxAlertStates = 25
+
+// stepAlerts advances the named rooms' alert states with their
+// readings in one transaction, so batches processed at the same time
+// can't each send the same alert.
+func stepAlerts(c context.Context, s *site, hc *HouseConfig, names []string, bySerial map[string]Readings) ([]*Alert, error) {
+	var alerts []*Alert
+	err := platform.Default.Blobs.RunInTransaction(c, func(tc context.Context) error {
+		alerts = nil
+		states, err := loadAlertStates(tc, s, names)
+		if err != nil {
+			return err
+		}
+		for i, name := range names {
+			room := hc.Rooms[name]
+			readings := bySerial[room.SN]
+			// Oldest first so durations make sense.
+			for j := len(readings) - 1; j >= 0; j-- {
+				if a := states[i].step(hc, name, room, readings[j]); a != nil {
+					a.Site = s.name
+					alerts = append(alerts, a)
+				}
+			}
+		}
+		return saveAlertStates(tc, s, names, states)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// evaluateAlerts runs a batch of readings from a site through every
+// affected room's alert state and sends whatever notifications fall
+// out.
+func evaluateAlerts(c context.Context, s *site, rs []Reading) error {
+	hc := s.config()
+
+	bySerial := map[string]Readings{}
+	for _, r := range rs {
+		bySerial[r.Serial] = append(bySerial[r.Serial], r)
+	}
+
+	var names []string
+	for name, room := range hc.Rooms {
+		if readings := bySerial[room.SN]; len(readings) > 0 {
+			readings.Sort()
+			names = append(names, name)
+		}
+	}
+
+	var alerts []*Alert
+	var errs []string
+	for len(names) > 0 {
+		n := len(names)
+		if n > maxAlertStates {
+			n = maxAlertStates
+		}
+		as, err := stepAlerts(c, s, hc, names[:n], bySerial)
+		if err != nil {
+			errs = append(errs, "updating alert states: "+err.Error())
+		}
+		alerts = append(alerts, as...)
+		names = names[n:]
+	}
+
+	for _, a := range alerts {
+		for _, n := range hc.Alerts.notifiers() {
+			if err := n.Notify(c, *a); err != nil {
+				errs = append(errs, "sending alert: "+err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package house
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+func TestAlertStep(t *testing.T) {
+	hc := &HouseConfig{}
+	hc.Alerts.Hysteresis = 1
+	hc.Alerts.MinDuration = Duration(10 * time.Minute)
+	room := &Room{SN: "x", Min: 10, Max: 30}
+
+	base := time.Date(2016, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset  time.Duration
+		reading float64
+		state   string
+		alert   string
+	}{
+		{0, 20, alertOK, ""},
+		{time.Minute, 31, alertPending, ""},
+		{5 * time.Minute, 20, alertOK, ""},
+		{6 * time.Minute, 32, alertPending, ""},
+		{12 * time.Minute, 33, alertPending, ""},
+		{16 * time.Minute, 33, alertFiring, "attic is high: 33.00°C"},
+		{20 * time.Minute, 35, alertFiring, ""},
+		// Inside bounds, but not by enough to clear.
+		{25 * time.Minute, 29.5, alertFiring, ""},
+		{30 * time.Minute, 28, alertOK, "attic is back to 28.00°C after 24m0s"},
+		{31 * time.Minute, 2, alertPending, ""},
+		{42 * time.Minute, 3, alertFiring, "attic is low: 3.00°C"},
+		{45 * time.Minute, 5, alertFiring, ""},
+		// Flipping to the other side alerts again.
+		{50 * time.Minute, 35, alertFiring, "attic is high: 35.00°C"},
+		{52 * time.Minute, 36, alertFiring, ""},
+		{55 * time.Minute, 20, alertOK, "attic is back to 20.00°C after 5m0s"},
+	}
+
+	hc.Unit = Celsius
+	st := &alertState{State: alertOK}
+	for _, test := range tests {
+		a := st.step(hc, "attic", room, Reading{Serial: "x", Reading: test.reading,
+			Timestamp: base.Add(test.offset)})
+		if st.State != test.state {
+			t.Errorf("Expected state %v after %v at %v, got %v",
+				test.state, test.reading, test.offset, st.State)
+		}
+		switch {
+		case a == nil && test.alert != "":
+			t.Errorf("Expected alert %q at %v, got none", test.alert, test.offset)
+		case a != nil && a.Text != test.alert:
+			t.Errorf("Expected alert %q at %v, got %q", test.alert, test.offset, a.Text)
+		}
+	}
+}
+
+func TestAlertConfigJSON(t *testing.T) {
+	ac := AlertConfig{}
+	err := json.Unmarshal([]byte(`{"minDuration": "15m", "log": true, "webhooks": ["http://x/"]}`), &ac)
+	if err != nil {
+		t.Fatalf("Error parsing alert config: %v", err)
+	}
+	if time.Duration(ac.MinDuration) != 15*time.Minute {
+		t.Errorf("Expected 15m, got %v", time.Duration(ac.MinDuration))
+	}
+	if n := len(ac.notifiers()); n != 2 {
+		t.Errorf("Expected 2 notifiers, got %v", n)
+	}
+}
+
+// slowBlobs takes its time storing records, so concurrent updates
+// overlap.
+type slowBlobs struct {
+	platform.BlobStore
+}
+
+func (b slowBlobs) PutMulti(c context.Context, kind string, names []string, src interface{}) error {
+	time.Sleep(10 * time.Millisecond)
+	return b.BlobStore.PutMulti(c, kind, names, src)
+}
+
+func TestConcurrentAlerts(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+	platform.Default.Blobs = slowBlobs{platform.Default.Blobs}
+
+	c := context.Background()
+	s := getSite("shed")
+	_, err := s.storeConfig(c, []byte(`{"dims": {"w": 100, "h": 100}, "image": "/static/house/house.png",
+		"alerts": {"email": ["someone@example.com"]},
+		"rooms": {"shed": {"sn": "S1", "min": 1, "max": 30, "rect": {"w": 10, "h": 10}}}}`), "")
+	if err != nil {
+		t.Fatalf("Error storing config: %v", err)
+	}
+	if err := s.refresh(c); err != nil {
+		t.Fatalf("Error loading shed: %v", err)
+	}
+
+	// Batches processed at once must agree on who sends the alert.
+	base := time.Date(2016, 1, 15, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := Reading{Serial: "S1", Reading: 40, Timestamp: base.Add(time.Duration(i) * time.Second)}
+			if err := evaluateAlerts(c, s, []Reading{r}); err != nil {
+				t.Errorf("Error evaluating alerts: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sent, _ := filepath.Glob(filepath.Join(h.dir, "mail", "*.eml"))
+	if len(sent) != 1 {
+		t.Errorf("Expected one alert sent, got %v", len(sent))
+	}
+}
+
+func TestAlertsWithoutSN(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	c := context.Background()
+	s := getSite("barn")
+	_, err := s.storeConfig(c, []byte(`{"dims": {"w": 100, "h": 100}, "image": "/static/house/house.png",
+		"alerts": {"email": ["someone@example.com"]},
+		"rooms": {"barn": {"min": 1, "max": 30, "rect": {"w": 10, "h": 10}}}}`), "")
+	if err != nil {
+		t.Fatalf("Error storing config: %v", err)
+	}
+	if err := s.refresh(c); err != nil {
+		t.Fatalf("Error loading barn: %v", err)
+	}
+
+	// A room with no sn is read by its name.
+	r := Reading{Serial: "barn", Reading: 40, Timestamp: time.Date(2016, 1, 15, 0, 0, 0, 0, time.UTC)}
+	if err := evaluateAlerts(c, s, []Reading{r}); err != nil {
+		t.Fatalf("Error evaluating alerts: %v", err)
+	}
+	sent, _ := filepath.Glob(filepath.Join(h.dir, "mail", "*.eml"))
+	if len(sent) != 1 {
+		t.Errorf("Expected an alert for the barn, got %v", len(sent))
+	}
+}
@@ -0,0 +1,201 @@
+package house
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+// An apiReading is a reading as presented by the export API.
+type apiReading struct {
+	Serial    string    `json:"serial"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"ts"`
+	Reading   float64   `json:"reading"`
+	Unit      Unit      `json:"unit,omitempty"`
+}
+
+// toAPI converts a reading for output, in the given units if possible.
+func toAPI(hc *HouseConfig, r *Reading, units Unit) apiReading {
+	v, u := hc.UnitOf(hc.BySerial(r.Serial)).Convert(r.Reading, units)
+	return apiReading{
+		Serial:    r.Serial,
+		Name:      hc.NameOf(r.Serial),
+		Timestamp: r.Timestamp,
+		Reading:   v,
+		Unit:      u,
+	}
+}
+
+// negotiate picks an output format from the format parameter or the
+// Accept header.  JSON wins unless the client prefers CSV.
+func negotiate(r *http.Request) string {
+	switch f := r.FormValue("format"); f {
+	case "json", "csv":
+		return f
+	}
+
+	best, bestq := "json", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		var f string
+		switch mt {
+		case "text/csv":
+			f = "csv"
+		case "application/json", "*/*":
+			f = "json"
+		default:
+			continue
+		}
+		if q > bestq {
+			best, bestq = f, q
+		}
+	}
+	return best
+}
+
+func writeReadings(c context.Context, w http.ResponseWriter, r *http.Request, rs []apiReading) {
+	if rs == nil {
+		rs = []apiReading{}
+	}
+	w.Header().Set("Vary", "Accept")
+
+	if negotiate(r) == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"serial", "name", "ts", "reading", "unit"})
+		for _, rd := range rs {
+			cw.Write([]string{rd.Serial, rd.Name,
+				rd.Timestamp.Format(time.RFC3339Nano),
+				strconv.FormatFloat(rd.Reading, 'f', -1, 64),
+				string(rd.Unit)})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Warningf(c, "Error writing CSV: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rs); err != nil {
+		log.Warningf(c, "Error writing JSON: %v", err)
+	}
+}
+
+func sortedSerials(m map[string][]*Reading) []string {
+	rv := make([]string, 0, len(m))
+	for k := range m {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// HandleAPICurrent serves the latest reading from every sensor at a
+// site.
+//
+// An optional units parameter converts readings where possible.
+func HandleAPICurrent(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	s, err := requestSite(c, r)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	hc := s.config()
+	units := ParseUnit(r.FormValue("units"))
+
+	alldata := getReadings(c, s)
+
+	var rv []apiReading
+	for _, sn := range sortedSerials(alldata) {
+		if rs := alldata[sn]; len(rs) > 0 {
+			rs[0].Serial = sn
+			rv = append(rv, toAPI(hc, rs[0], units))
+		}
+	}
+
+	writeReadings(c, w, r, rv)
+}
+
+// HandleAPIReadings serves readings for one or more sensors.
+//
+// With no from or to parameter, the recent readings held in cache are
+// returned.  Otherwise they come from history as with HandleHistory.
+// sn may be repeated and defaults to every known sensor at the site:
+// those in cache for recent readings, or in its config for history.
+// units converts readings as for HandleAPICurrent.
+func HandleAPIReadings(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	s, err := requestSite(c, r)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	hc := s.config()
+	r.ParseForm()
+	units := ParseUnit(r.FormValue("units"))
+
+	sns := r.Form["sn"]
+
+	var rv []apiReading
+	if r.FormValue("from") == "" && r.FormValue("to") == "" {
+		recent := getReadings(c, s)
+		if len(sns) == 0 {
+			sns = sortedSerials(recent)
+		}
+		for _, sn := range sns {
+			for _, rd := range recent[sn] {
+				rd.Serial = sn
+				rv = append(rv, toAPI(hc, rd, units))
+			}
+		}
+		writeReadings(c, w, r, rv)
+		return
+	}
+
+	from, to, limit, err := parseRange(r)
+	if err != nil {
+		showError(c, w, "Invalid range: "+err.Error(), 400)
+		return
+	}
+
+	// The cache may have forgotten sensors that have history.
+	if len(sns) == 0 {
+		sns = hc.Serials()
+	}
+	for _, sn := range sns {
+		rs, err := history.Range(c, s.key(sn), from, to, limit)
+		if err != nil {
+			showError(c, w, fmt.Sprintf("Error fetching history for %v: %v", sn, err), 500)
+			return
+		}
+		for i := range rs {
+			rs[i].Serial = sn
+			rv = append(rv, toAPI(hc, &rs[i], units))
+		}
+	}
+
+	writeReadings(c, w, r, rv)
+}
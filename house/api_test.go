@@ -0,0 +1,31 @@
+package house
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		url, accept, exp string
+	}{
+		{"/", "", "json"},
+		{"/", "text/csv", "csv"},
+		{"/", "application/json, text/csv;q=0.5", "json"},
+		{"/", "application/json;q=0.2, text/csv", "csv"},
+		{"/", "text/html, */*;q=0.1", "json"},
+		{"/?format=csv", "application/json", "csv"},
+		{"/?format=xml", "text/csv", "csv"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if got := negotiate(r); got != test.exp {
+			t.Errorf("Expected %v for %v with %q, got %v",
+				test.exp, test.url, test.accept, got)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package house
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+const (
+	maxSignedBody = 4 << 20
+	maxClockSkew  = 5 * time.Minute
+)
+
+var (
+	errNoCredentials  = errors.New("no credentials")
+	errBadCredentials = errors.New("bad credentials")
+	errBadSignature   = errors.New("bad signature")
+	errStale          = errors.New("timestamp too far from now")
+	errTooLarge       = errors.New("body too large to sign")
+
+	validDeviceName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// A Device is something allowed to post readings to /house/input/.
+//
+// Devices authenticate either with a bearer token of the form
+// name:secret, or by signing requests with the secret:
+//
+//	X-Device: name
+//	X-Timestamp: unix seconds
+//	X-Signature: hex(HMAC-SHA256(secret, timestamp + "\n" + body))
+type Device struct {
+	Name   string
+	Secret string `datastore:",noindex"`
+	// Serial numbers (or path.Match patterns) this device may report.
+	Serials []string `datastore:",noindex"`
+	// If any are given, requests must come from one of these
+	// addresses or networks.
+	AllowedIPs []string `datastore:",noindex"`
+	Disabled   bool
+	Created    time.Time
+}
+
+// Token is the bearer token for this device.
+func (d *Device) Token() string {
+	return d.Name + ":" + d.Secret
+}
+
+// Allows reports whether this device may report the given serial.
+func (d *Device) Allows(sn string) bool {
+	for _, p := range d.Serials {
+		if ok, _ := path.Match(p, sn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Device) allowsIP(ip net.IP) bool {
+	return len(d.AllowedIPs) == 0 || ipListed(d.AllowedIPs, ip)
+}
+
+// ipListed reports whether ip is one of the addresses or CIDR networks
+// in l.
+func ipListed(l []string, ip net.IP) bool {
+	for _, a := range l {
+		if _, n, err := net.ParseCIDR(a); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if aip := net.ParseIP(a); aip != nil && aip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies may tell us who a client is with X-Forwarded-For.
+// They're set in HOUSE_TRUSTED_PROXIES as addresses or CIDR networks.
+var trustedProxies = splitList(os.Getenv("HOUSE_TRUSTED_PROXIES"))
+
+// clientIP finds the address a request came from.  That's the peer
+// unless it's a trusted proxy, in which case X-Forwarded-For is
+// followed back through trusted proxies to the first one that isn't.
+func clientIP(r *http.Request) net.IP {
+	addr := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	ip := net.ParseIP(addr)
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && ip != nil && ipListed(trustedProxies, ip); i-- {
+		next := net.ParseIP(strings.TrimSpace(hops[i]))
+		if next == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
+
+func newSecret() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// signature computes the expected X-Signature for a request.
+func signature(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(h, ts+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const deviceKind = "Device"
+
+func loadDevice(c context.Context, name string) (*Device, error) {
+	d := &Device{}
+	err := platform.Default.Blobs.Get(c, deviceKind, name, d)
+	return d, err
+}
+
+func saveDevice(c context.Context, d *Device) error {
+	return platform.Default.Blobs.Put(c, deviceKind, d.Name, d)
+}
+
+func listDevices(c context.Context) ([]*Device, error) {
+	names, err := platform.Default.Blobs.Names(c, deviceKind)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	rv := make([]*Device, len(names))
+	for i := range rv {
+		rv[i] = &Device{}
+	}
+	return rv, platform.Default.Blobs.GetMulti(c, deviceKind, names, rv)
+}
+
+// authenticate figures out which device sent a request.
+func authenticate(c context.Context, r *http.Request) (*Device, error) {
+	var name, secret string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		parts := strings.SplitN(strings.TrimPrefix(auth, "Bearer "), ":", 2)
+		if len(parts) != 2 {
+			return nil, errBadCredentials
+		}
+		name, secret = parts[0], parts[1]
+	} else if name = r.Header.Get("X-Device"); name == "" {
+		return nil, errNoCredentials
+	}
+
+	d, err := loadDevice(c, name)
+	if err == platform.ErrNoSuchEntity {
+		return nil, errBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Disabled {
+		return nil, errBadCredentials
+	}
+
+	if secret != "" {
+		if !hmac.Equal([]byte(secret), []byte(d.Secret)) {
+			return nil, errBadCredentials
+		}
+	} else if err := checkSignature(r, d); err != nil {
+		return nil, err
+	}
+
+	if ip := clientIP(r); !d.allowsIP(ip) {
+		return nil, fmt.Errorf("%v may not post from %v", d.Name, ip)
+	}
+
+	return d, nil
+}
+
+// checkSignature verifies a signed request, leaving the body in place
+// for whoever reads it next.
+func checkSignature(r *http.Request, d *Device) error {
+	ts := r.Header.Get("X-Timestamp")
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	skew := time.Since(time.Unix(secs, 0))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return errStale
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSignedBody {
+		return errTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	exp := signature(d.Secret, ts, body)
+	if !hmac.Equal([]byte(exp), []byte(strings.ToLower(r.Header.Get("X-Signature")))) {
+		return errBadSignature
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+// HandleDevices is the admin page for managing devices allowed to post
+// readings.
+func HandleDevices(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	var msg string
+	if r.Method == "POST" {
+		var err error
+		msg, err = updateDevice(c, r)
+		if err != nil {
+			showError(c, w, err.Error(), 400)
+			return
+		}
+	}
+
+	devices, err := listDevices(c)
+	if err != nil {
+		showError(c, w, "Error listing devices: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "devices.html", struct {
+		Message string
+		Devices []*Device
+	}{msg, devices})
+	if err != nil {
+		log.Errorf(c, "Error rendering devices: %v", err)
+	}
+}
+
+func updateDevice(c context.Context, r *http.Request) (string, error) {
+	name := r.FormValue("name")
+	if !validDeviceName.MatchString(name) {
+		return "", fmt.Errorf("invalid device name %q", name)
+	}
+
+	action := r.FormValue("action")
+	if action == "delete" {
+		return "Deleted " + name, platform.Default.Blobs.Delete(c, deviceKind, name)
+	}
+
+	d, err := loadDevice(c, name)
+	switch {
+	case err == platform.ErrNoSuchEntity:
+		if action != "create" {
+			return "", fmt.Errorf("no such device: %v", name)
+		}
+		d = &Device{Name: name, Secret: newSecret(), Created: time.Now()}
+	case err != nil:
+		return "", err
+	case action == "create":
+		return "", fmt.Errorf("%v already exists", name)
+	}
+
+	for _, a := range splitList(r.FormValue("allowedIPs")) {
+		if net.ParseIP(a) == nil {
+			if _, _, err := net.ParseCIDR(a); err != nil {
+				return "", fmt.Errorf("invalid address %q", a)
+			}
+		}
+	}
+
+	msg := "Updated " + name
+	switch action {
+	case "create":
+		msg = "Created " + name + " with token " + d.Token()
+	case "rekey":
+		d.Secret = newSecret()
+		msg = "New token for " + name + ": " + d.Token()
+	}
+	if action != "rekey" {
+		d.Serials = splitList(r.FormValue("serials"))
+		d.AllowedIPs = splitList(r.FormValue("allowedIPs"))
+		d.Disabled = r.FormValue("disabled") != ""
+	}
+
+	return msg, saveDevice(c, d)
+}
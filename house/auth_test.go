@@ -0,0 +1,103 @@
+package house
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeviceAllows(t *testing.T) {
+	d := &Device{Serials: []string{"10E8C214000000E4", "28*"}}
+	tests := map[string]bool{
+		"10E8C214000000E4": true,
+		"10E8C214000000E5": false,
+		"28FF0001":         true,
+		"":                 false,
+	}
+	for sn, exp := range tests {
+		if got := d.Allows(sn); got != exp {
+			t.Errorf("Expected %v for %q, got %v", exp, sn, got)
+		}
+	}
+
+	if (&Device{}).Allows("x") {
+		t.Errorf("A device with no serials shouldn't allow anything")
+	}
+	if !(&Device{Serials: []string{"*"}}).Allows("x") {
+		t.Errorf("* should allow anything")
+	}
+}
+
+func TestDeviceAllowsIP(t *testing.T) {
+	defer func(old []string) { trustedProxies = old }(trustedProxies)
+	trustedProxies = []string{"127.0.0.1", "10.9.0.0/16"}
+
+	d := &Device{AllowedIPs: []string{"162.230.117.10", "10.0.0.0/8"}}
+	tests := []struct {
+		remote, fwd string
+		exp         bool
+	}{
+		{"162.230.117.10:1234", "", true},
+		{"162.230.117.11:1234", "", false},
+		{"10.3.2.1:80", "", true},
+		// Only trusted proxies get to say who the client is.
+		{"127.0.0.1:80", "162.230.117.10", true},
+		{"127.0.0.1:80", "8.8.8.8", false},
+		{"8.8.8.8:80", "162.230.117.10", false},
+		// A client can put anything at the front; we only believe
+		// what the trusted proxies added.
+		{"127.0.0.1:80", "162.230.117.10, 8.8.8.8", false},
+		{"127.0.0.1:80", "8.8.8.8, 162.230.117.10, 10.9.1.1", true},
+		{"127.0.0.1:80", "garbage", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/house/input/", nil)
+		r.RemoteAddr = test.remote
+		if test.fwd != "" {
+			r.Header.Set("X-Forwarded-For", test.fwd)
+		}
+		if got := d.allowsIP(clientIP(r)); got != test.exp {
+			t.Errorf("Expected %v for %v/%v, got %v", test.exp, test.remote, test.fwd, got)
+		}
+	}
+
+	if !(&Device{}).allowsIP(nil) {
+		t.Errorf("No allowlist should allow anything")
+	}
+}
+
+func TestSignatureTooLarge(t *testing.T) {
+	d := &Device{Name: "d", Secret: "secret"}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	body := bytes.Repeat([]byte("x"), maxSignedBody)
+	r := httptest.NewRequest("POST", "/house/input/", bytes.NewReader(body))
+	r.Header.Set("X-Timestamp", ts)
+	r.Header.Set("X-Signature", signature(d.Secret, ts, body))
+	if err := checkSignature(r, d); err != nil {
+		t.Errorf("Expected a body at the limit to pass, got %v", err)
+	}
+
+	body = append(body, 'x')
+	r = httptest.NewRequest("POST", "/house/input/", bytes.NewReader(body))
+	r.Header.Set("X-Timestamp", ts)
+	r.Header.Set("X-Signature", signature(d.Secret, ts, body))
+	if err := checkSignature(r, d); err != errTooLarge {
+		t.Errorf("Expected a body over the limit to be too large, got %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	a := signature("secret", "1450000000", []byte("sn=x&ts=y&r=1"))
+	if a != signature("secret", "1450000000", []byte("sn=x&ts=y&r=1")) {
+		t.Errorf("Signatures aren't stable")
+	}
+	if a == signature("secret", "1450000001", []byte("sn=x&ts=y&r=1")) {
+		t.Errorf("Timestamp isn't covered by signature")
+	}
+	if a == signature("other", "1450000000", []byte("sn=x&ts=y&r=1")) {
+		t.Errorf("Secret isn't covered by signature")
+	}
+}
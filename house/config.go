@@ -2,8 +2,16 @@ package house
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
-	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"platform"
 )
 
 type Rect struct {
@@ -15,14 +23,25 @@ type Point struct {
 }
 
 // Room definition.
+//
+// A room is a Rect unless it has a Polygon, in which case Rect is
+// worked out as the polygon's bounds.  Coordinates are relative to the
+// plan of the room's Floor.  A room with no SN is its own sensor's
+// serial number, and ParseConfig fills that in.
 type Room struct {
 	SN      string
 	Max     float64
 	Min     float64
 	Rect    Rect
+	Polygon []Point
+	Floor   string
 	Therm   Point
 	Spark   Rect
 	Reading Point
+	Unit    Unit
+
+	// Overrides HouseConfig.Alerts.Hysteresis.
+	Hysteresis float64
 
 	Latest float64
 
@@ -37,6 +56,14 @@ func (r *Room) SparkWidth() int {
 	return r.Rect.W
 }
 
+// A Floor is one level of a house with its own plan image, which is a
+// URL or a path on this server.  Without one it's <name>.png shipped
+// alongside the site's house.json.
+type Floor struct {
+	Name  string
+	Image string
+}
+
 // HouseConfig holds the configuration for all the house things.
 type HouseConfig struct {
 	Dims struct {
@@ -44,9 +71,36 @@ type HouseConfig struct {
 		W int
 	}
 	MaxRelevantDistance float64
+	Unit                Unit
 	Rooms               map[string]*Room
 	bySerial            map[string]*Room
 	Colorize            []string
+	Alerts              AlertConfig
+	// Image is the floor plan rooms are drawn over, as a URL or a
+	// path on this server.  It defaults to the house.png shipped
+	// alongside the site's house.json.  It's ignored if there are
+	// Floors.
+	Image string
+	// Floors, if given, are drawn side by side in this order.
+	// Rooms that don't name a floor are on the first.
+	Floors []Floor
+}
+
+// floors lists the floors to draw, which is a single unnamed one
+// unless Floors are configured.
+func (hc *HouseConfig) floors() []Floor {
+	if len(hc.Floors) > 0 {
+		return hc.Floors
+	}
+	return []Floor{{Image: hc.Image}}
+}
+
+// floorOf names the floor a room is on.
+func (hc *HouseConfig) floorOf(room *Room) string {
+	if room.Floor != "" {
+		return room.Floor
+	}
+	return hc.floors()[0].Name
 }
 
 // NameOf returns the name for this Serial Number
@@ -63,28 +117,87 @@ func (hc *HouseConfig) BySerial(sn string) *Room {
 	return hc.bySerial[sn]
 }
 
-// LoadConfig loads the from a JSON file.
-func LoadConfig(path string) (conf HouseConfig, err error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return conf, err
+// Serials lists the serial numbers of every room, sorted.
+func (hc *HouseConfig) Serials() []string {
+	rv := make([]string, 0, len(hc.bySerial))
+	for sn := range hc.bySerial {
+		rv = append(rv, sn)
 	}
-	defer f.Close()
+	sort.Strings(rv)
+	return rv
+}
 
-	err = json.NewDecoder(f).Decode(&conf)
-	if err != nil {
-		return
+// embeddedConfigs are configurations compiled into a binary.
+var embeddedConfigs = map[string][]byte{}
+
+// EmbedConfig makes a configuration document available to LoadConfig
+// as embedded:name.
+func EmbedConfig(name string, data []byte) {
+	embeddedConfigs[name] = data
+}
+
+// readConfig fetches a configuration document from a source, which
+// is one of:
+//
+//	http(s)://...   -- a URL
+//	embedded:name   -- something given to EmbedConfig
+//	anything else   -- a local file (file:// is optional)
+func readConfig(c context.Context, src string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+		res, err := platform.HTTPClient(c).Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("http error on %v: %v", src, res.Status)
+		}
+		return ioutil.ReadAll(io.LimitReader(res.Body, maxConfigSize))
+	case strings.HasPrefix(src, "embedded:"):
+		data, ok := embeddedConfigs[src[len("embedded:"):]]
+		if !ok {
+			return nil, fmt.Errorf("no embedded config named %v", src)
+		}
+		return data, nil
+	}
+	return ioutil.ReadFile(strings.TrimPrefix(src, "file://"))
+}
+
+// ParseConfig decodes, indexes and validates a configuration
+// document.  The config is returned even if it fails validation so
+// long as it could be decoded.
+func ParseConfig(data []byte) (*HouseConfig, error) {
+	hc := &HouseConfig{}
+	if err := json.Unmarshal(data, hc); err != nil {
+		return nil, err
 	}
 
-	conf.bySerial = make(map[string]*Room)
-	for k, r := range conf.Rooms {
-		sn := r.SN
-		if sn == "" {
-			sn = k
+	hc.bySerial = make(map[string]*Room)
+	for k, r := range hc.Rooms {
+		if r == nil {
+			continue
+		}
+		if r.SN == "" {
+			r.SN = k
+		}
+		hc.bySerial[r.SN] = r
+		if len(r.Polygon) > 0 {
+			r.Rect = polygonBounds(r.Polygon)
 		}
-		conf.bySerial[sn] = r
 		r.Latest = math.NaN()
 		r.Name = k
 	}
-	return
+
+	return hc, hc.Validate()
+}
+
+// LoadConfig reads and parses a configuration from any source
+// readConfig understands.
+func LoadConfig(c context.Context, src string) (*HouseConfig, error) {
+	data, err := readConfig(c, src)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
 }
@@ -0,0 +1,377 @@
+package house
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+const (
+	configKind       = "HouseConfig"
+	configActiveKind = "HouseConfigActive"
+	configCheckEvery = time.Minute
+	maxConfigSize    = 1 << 20
+)
+
+// A ConfigVersion is one uploaded revision of a site's configuration.
+// Each site numbers its versions separately.
+type ConfigVersion struct {
+	Version  int
+	Data     []byte `datastore:",noindex"`
+	Comment  string `datastore:",noindex"`
+	Uploaded time.Time
+}
+
+// activeConfig records which ConfigVersion a site uses.  It's stored
+// under the site's name.  Version 0 is the house.json that shipped
+// with the app.
+type activeConfig struct {
+	Version int
+}
+
+// ConfigErrors lists everything wrong with a configuration.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate checks that the configuration makes sense: every room fits
+// inside Dims with Min below Max and is on a known floor, polygons
+// have at least three points, serial numbers are unique, and
+// everything in Colorize is a room.
+func (hc *HouseConfig) Validate() error {
+	var errs ConfigErrors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if hc.Dims.W <= 0 || hc.Dims.H <= 0 {
+		add("dims must be positive, got %vx%v", hc.Dims.W, hc.Dims.H)
+	}
+	if len(hc.Rooms) == 0 {
+		add("no rooms defined")
+	}
+
+	floors := map[string]bool{}
+	for _, f := range hc.Floors {
+		if f.Name == "" {
+			add("floors must have names")
+		} else if floors[f.Name] {
+			add("floor %v is defined twice", f.Name)
+		}
+		floors[f.Name] = true
+	}
+
+	names := make([]string, 0, len(hc.Rooms))
+	for name := range hc.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	serials := map[string]string{}
+	for _, name := range names {
+		r := hc.Rooms[name]
+		if r == nil {
+			add("room %v is empty", name)
+			continue
+		}
+		if r.Min >= r.Max {
+			add("room %v: min (%v) must be less than max (%v)", name, r.Min, r.Max)
+		}
+		if n := len(r.Polygon); n > 0 && n < 3 {
+			add("room %v: polygon needs at least 3 points, has %v", name, n)
+		}
+		if r.Floor != "" && !floors[r.Floor] {
+			add("room %v is on unknown floor %v", name, r.Floor)
+		}
+		rc := r.Rect
+		if rc.W <= 0 || rc.H <= 0 || rc.X < 0 || rc.Y < 0 ||
+			rc.X+rc.W > hc.Dims.W || rc.Y+rc.H > hc.Dims.H {
+			add("room %v: rect %+v is outside %vx%v", name, rc, hc.Dims.W, hc.Dims.H)
+		}
+		sn := r.SN
+		if sn == "" {
+			sn = name
+		}
+		if other, ok := serials[sn]; ok {
+			add("rooms %v and %v share serial %v", other, name, sn)
+		}
+		serials[sn] = name
+	}
+
+	for _, name := range hc.Colorize {
+		if _, ok := hc.Rooms[name]; !ok {
+			add("colorize names unknown room %v", name)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func versionName(v int) string {
+	return fmt.Sprintf("%08d", v)
+}
+
+// configVersions lists every configuration stored for the site,
+// newest first.
+func (s *site) configVersions(c context.Context) ([]*ConfigVersion, error) {
+	all, err := platform.Default.Blobs.Names(c, configKind)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range all {
+		if strings.HasPrefix(n, s.key("")) && !strings.Contains(n[len(s.key("")):], "/") {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	rv := make([]*ConfigVersion, len(names))
+	for i := range rv {
+		rv[i] = &ConfigVersion{}
+	}
+	if err := platform.Default.Blobs.GetMulti(c, configKind, names, rv); err != nil {
+		return nil, err
+	}
+	sort.Sort(newestFirst(rv))
+	return rv, nil
+}
+
+type newestFirst []*ConfigVersion
+
+func (n newestFirst) Len() int           { return len(n) }
+func (n newestFirst) Less(i, j int) bool { return n[i].Version > n[j].Version }
+func (n newestFirst) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+func (s *site) loadConfigVersion(c context.Context, v int) (*ConfigVersion, error) {
+	cv := &ConfigVersion{}
+	err := platform.Default.Blobs.Get(c, configKind, s.key(versionName(v)), cv)
+	return cv, err
+}
+
+func (s *site) activeVersion(c context.Context) (int, error) {
+	ac := activeConfig{}
+	err := platform.Default.Blobs.Get(c, configActiveKind, s.name, &ac)
+	if err == platform.ErrNoSuchEntity {
+		return 0, nil
+	}
+	return ac.Version, err
+}
+
+// activateConfig puts a stored version (or 0 for the shipped one) into
+// use.  Instances pick it up within configCheckEvery.
+func (s *site) activateConfig(c context.Context, v int) error {
+	if v != 0 {
+		if _, err := s.loadConfigVersion(c, v); err != nil {
+			return fmt.Errorf("loading version %v: %v", v, err)
+		}
+	}
+	defer forgetSiteNames()
+	return platform.Default.Blobs.Put(c, configActiveKind, s.name, &activeConfig{v})
+}
+
+// checkSerials makes sure no other site already claims a sensor in
+// the given config, since readings are routed to sites by serial.
+func (s *site) checkSerials(c context.Context, hc *HouseConfig) error {
+	var errs ConfigErrors
+	for _, n := range knownSites(c) {
+		if n == s.name {
+			continue
+		}
+		other := getSite(n)
+		if err := other.refresh(c); err != nil {
+			log.Warningf(c, "Can't check serials against site %v: %v", n, err)
+		}
+		for _, sn := range hc.Serials() {
+			if other.config().BySerial(sn) != nil {
+				errs = append(errs, fmt.Sprintf("serial %v belongs to site %v", sn, other.name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// storeConfig validates a configuration document and saves it as the
+// site's next version, making it active.
+func (s *site) storeConfig(c context.Context, data []byte, comment string) (*ConfigVersion, error) {
+	hc, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSerials(c, hc); err != nil {
+		return nil, err
+	}
+
+	// The listing may be behind, so the version is claimed in a
+	// transaction, skipping past any that turn out to be taken.
+	versions, err := s.configVersions(c)
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[0].Version + 1
+	}
+	cv := &ConfigVersion{Data: data, Comment: comment, Uploaded: time.Now()}
+	err = platform.Default.Blobs.RunInTransaction(c, func(tc context.Context) error {
+		for cv.Version = next; ; cv.Version++ {
+			err := platform.Default.Blobs.Get(tc, configKind, s.key(versionName(cv.Version)), &ConfigVersion{})
+			if err == platform.ErrNoSuchEntity {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return platform.Default.Blobs.Put(tc, configKind, s.key(versionName(cv.Version)), cv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cv, s.activateConfig(c, cv.Version)
+}
+
+// shippedConfigSource is where the configuration that shipped with
+// the app is read from.  HOUSE_CONFIG may point elsewhere for the
+// default site.
+func (s *site) shippedConfigSource(c context.Context) string {
+	if src := os.Getenv("HOUSE_CONFIG"); src != "" && s.name == DefaultSite {
+		return src
+	}
+	return "http://" + platform.Hostname(c) + s.static("house.json")
+}
+
+// configData returns a stored version, or the shipped config for 0.
+func (s *site) configData(c context.Context, v int) ([]byte, error) {
+	if v == 0 {
+		return readConfig(c, s.shippedConfigSource(c))
+	}
+	cv, err := s.loadConfigVersion(c, v)
+	if err != nil {
+		return nil, err
+	}
+	return cv.Data, nil
+}
+
+// HandleConfig is the admin page for uploading, inspecting and
+// activating versions of each site's configuration.  The site
+// parameter picks the site, defaulting to DefaultSite.  Uploading a
+// config for a site that doesn't exist yet creates it.
+func HandleConfig(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	name := r.FormValue("site")
+	if name == "" {
+		name = DefaultSite
+	}
+	if err := validSiteName(name); err != nil {
+		showError(c, w, err.Error(), 400)
+		return
+	}
+	s := getSite(name)
+
+	var msg string
+	if r.Method == "POST" {
+		var err error
+		msg, err = s.updateConfig(c, r)
+		if err != nil {
+			showError(c, w, err.Error(), 400)
+			return
+		}
+	}
+
+	if v := r.FormValue("show"); v != "" && r.Method == "GET" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			showError(c, w, "Invalid version", 400)
+			return
+		}
+		data, err := s.configData(c, n)
+		if err != nil {
+			showError(c, w, err.Error(), 404)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	versions, err := s.configVersions(c)
+	if err != nil {
+		showError(c, w, "Error listing config versions: "+err.Error(), 500)
+		return
+	}
+	active, err := s.activeVersion(c)
+	if err != nil {
+		showError(c, w, "Error finding active config: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "config.html", struct {
+		Message  string
+		Site     string
+		Sites    []string
+		Active   int
+		Versions []*ConfigVersion
+	}{msg, s.name, knownSites(c), active, versions})
+	if err != nil {
+		log.Errorf(c, "Error rendering config page: %v", err)
+	}
+}
+
+func (s *site) updateConfig(c context.Context, r *http.Request) (string, error) {
+	if v := r.FormValue("activate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid version %q", v)
+		}
+		if err := s.activateConfig(c, n); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Activated version %v", n), nil
+	}
+
+	data := []byte(r.FormValue("config"))
+	if f, _, err := r.FormFile("file"); err == nil {
+		defer f.Close()
+		data, err = ioutil.ReadAll(io.LimitReader(f, maxConfigSize+1))
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(data) > maxConfigSize {
+		return "", fmt.Errorf("configuration is over the %v byte limit", maxConfigSize)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", fmt.Errorf("no configuration given")
+	}
+
+	cv, err := s.storeConfig(c, data, r.FormValue("comment"))
+	if err != nil {
+		return "", fmt.Errorf("invalid configuration: %v", err)
+	}
+	return fmt.Sprintf("Stored and activated version %v", cv.Version), nil
+}
@@ -0,0 +1,177 @@
+package house
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+func TestShippedConfigValid(t *testing.T) {
+	data, err := ioutil.ReadFile("../static/house/house.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConfig(data); err != nil {
+		t.Errorf("Shipped config is invalid: %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		doc  string
+		errs []string
+	}{
+		{`{"dims": {"w": 100, "h": 100},
+		   "rooms": {"a": {"sn": "1", "min": 1, "max": 2, "rect": {"x": 0, "y": 0, "w": 10, "h": 10}}},
+		   "colorize": ["a"]}`, nil},
+		{`{"dims": {"w": 100, "h": 100},
+		   "rooms": {"a": {"sn": "1", "min": 3, "max": 2, "rect": {"x": 95, "y": 0, "w": 10, "h": 10}},
+		             "b": {"sn": "1", "min": 1, "max": 2, "rect": {"x": 0, "y": 0, "w": 10, "h": 10}}},
+		   "colorize": ["a", "c"]}`,
+			[]string{"min (3) must be less than max", "outside 100x100",
+				"rooms a and b share serial 1", "unknown room c"}},
+		{`{"rooms": {}}`, []string{"dims must be positive", "no rooms"}},
+	}
+
+	for i, test := range tests {
+		_, err := ParseConfig([]byte(test.doc))
+		if test.errs == nil {
+			if err != nil {
+				t.Errorf("Test %v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		errs, ok := err.(ConfigErrors)
+		if !ok || len(errs) != len(test.errs) {
+			t.Errorf("Test %v: expected %v errors, got %v", i, len(test.errs), err)
+			continue
+		}
+		for j, exp := range test.errs {
+			if !strings.Contains(errs[j], exp) {
+				t.Errorf("Test %v: expected %q in %q", i, exp, errs[j])
+			}
+		}
+	}
+}
+
+func TestConfigVersions(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	c := context.Background()
+	s := getSite("cabin")
+	doc := func(max int) []byte {
+		return []byte(`{"dims": {"w": 100, "h": 100}, "image": "/static/house/house.png",
+			"rooms": {"a": {"min": 1, "max": ` + string('0'+rune(max)) + `, "rect": {"w": 10, "h": 10}}}}`)
+	}
+
+	if _, err := s.storeConfig(c, []byte(`{"rooms": {}}`), "bad"); err == nil {
+		t.Errorf("Expected an invalid config to be refused")
+	}
+
+	for i := 2; i <= 3; i++ {
+		cv, err := s.storeConfig(c, doc(i), "")
+		if err != nil {
+			t.Fatalf("Error storing config: %v", err)
+		}
+		if cv.Version != i-1 {
+			t.Errorf("Expected version %v, got %v", i-1, cv.Version)
+		}
+	}
+
+	versions, err := s.configVersions(c)
+	if err != nil || len(versions) != 2 || versions[0].Version != 2 {
+		t.Fatalf("Unexpected versions: %v, %v", versions, err)
+	}
+	if versions, err := defaultSite().configVersions(c); err != nil || len(versions) != 0 {
+		t.Errorf("Expected no versions for the default site, got %v, %v", versions, err)
+	}
+
+	if err := s.refresh(c); err != nil {
+		t.Fatalf("Error loading cabin: %v", err)
+	}
+	if got := s.config().Rooms["a"].Max; got != 3 {
+		t.Errorf("Expected version 2 in use, got max %v", got)
+	}
+
+	// Rolling back is also picked up, but not until it's time to check.
+	if err := s.activateConfig(c, 1); err != nil {
+		t.Fatalf("Error activating: %v", err)
+	}
+	s.refresh(c)
+	if got := s.config().Rooms["a"].Max; got != 3 {
+		t.Errorf("Expected version 2 until the next check, got max %v", got)
+	}
+	s.checked = time.Time{}
+	s.refresh(c)
+	if got := s.config().Rooms["a"].Max; got != 2 {
+		t.Errorf("Expected version 1 in use, got max %v", got)
+	}
+
+	if err := s.activateConfig(c, 7); err == nil {
+		t.Errorf("Expected an error activating a missing version")
+	}
+
+	// Another site can't claim the same sensor.
+	if _, err := getSite("barn").storeConfig(c, doc(2), ""); err == nil {
+		t.Errorf("Expected a config reusing a serial to be refused")
+	}
+}
+
+// staleNames lists nothing, like a query that hasn't caught up.
+type staleNames struct {
+	platform.BlobStore
+}
+
+func (staleNames) Names(c context.Context, kind string) ([]string, error) {
+	return nil, nil
+}
+
+func TestConfigVersionsStaleListing(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+	platform.Default.Blobs = staleNames{platform.Default.Blobs}
+
+	c := context.Background()
+	s := getSite("cabin")
+	doc := []byte(`{"dims": {"w": 100, "h": 100}, "image": "/static/house/house.png",
+		"rooms": {"a": {"min": 1, "max": 2, "rect": {"w": 10, "h": 10}}}}`)
+	for i := 1; i <= 3; i++ {
+		cv, err := s.storeConfig(c, doc, "")
+		if err != nil {
+			t.Fatalf("Error storing config: %v", err)
+		}
+		if cv.Version != i {
+			t.Errorf("Expected version %v, got %v", i, cv.Version)
+		}
+	}
+}
+
+func TestConfigTooLarge(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "house.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bytes.Repeat([]byte(" "), maxConfigSize+1))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/admin/house/config", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	msg, err := getSite("cabin").updateConfig(context.Background(), r)
+	if err == nil || !strings.Contains(err.Error(), "byte limit") {
+		t.Errorf("Expected a size error, got %q, %v", msg, err)
+	}
+}
@@ -0,0 +1,267 @@
+package house
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+const (
+	tmplGlob  = "templates/house/*.html"
+	chartW    = 400
+	chartH    = 100
+	chartSpan = 24 * time.Hour
+	chartRes  = "1h"
+)
+
+var (
+	templates     *template.Template
+	templatesOnce sync.Once
+)
+
+func getTemplates() *template.Template {
+	templatesOnce.Do(func() {
+		templates = template.Must(template.New("").ParseGlob(tmplGlob))
+	})
+	return templates
+}
+
+// A chart is a room's readings scaled to fit an SVG box.
+type chart struct {
+	W, H   int
+	Points string
+	// The room's Min/Max band.
+	BandY, BandH int
+	Low, High    float64
+	// Low and High for display.
+	LowText, HighText string
+}
+
+// roomStatus is everything the dashboard shows about a room.
+type roomStatus struct {
+	Name     string
+	Room     *Room
+	HasData  bool
+	Latest   float64
+	Updated  time.Time
+	Age      string
+	Stale    bool
+	State    string
+	Chart    *chart
+	Readings int
+	Floor    string
+	// Where the room is on the plan, if it's shown.
+	Box   Rect
+	Shown bool
+
+	// Latest, Room.Min and Room.Max for display.
+	LatestText, MinText, MaxText string
+}
+
+func newChart(room *Room, rs Readings, from, to time.Time) *chart {
+	if len(rs) < 2 {
+		return nil
+	}
+
+	low, high := room.Min, room.Max
+	for _, r := range rs {
+		low = math.Min(low, r.Reading)
+		high = math.Max(high, r.Reading)
+	}
+	if high == low {
+		high++
+	}
+
+	y := func(v float64) int {
+		return int(float64(chartH) - (float64(chartH) * (v - low) / (high - low)))
+	}
+	span := float64(to.Sub(from))
+
+	pts := make([]string, 0, len(rs))
+	for i := len(rs) - 1; i >= 0; i-- {
+		x := float64(chartW) * float64(rs[i].Timestamp.Sub(from)) / span
+		pts = append(pts, fmt.Sprintf("%.1f,%d", x, y(rs[i].Reading)))
+	}
+
+	return &chart{
+		W:      chartW,
+		H:      chartH,
+		Points: strings.Join(pts, " "),
+		BandY:  y(room.Max),
+		BandH:  y(room.Min) - y(room.Max),
+		Low:    low,
+		High:   high,
+	}
+}
+
+// chartReadings gets the last day's hourly means for a room, falling
+// back to whatever's in cache if history can't help.
+func chartReadings(c context.Context, s *site, room *Room, recent []*Reading, from, to time.Time) Readings {
+	rus, err := history.Rollups(c, s.key(room.SN), chartRes, from, to, int(chartSpan/time.Hour)+1)
+	if err != nil {
+		log.Warningf(c, "Error fetching rollups for %v: %v", room.SN, err)
+	}
+	var rs Readings
+	for _, ru := range rus {
+		rs = append(rs, Reading{Serial: room.SN, Reading: ru.Mean, Timestamp: ru.Start})
+	}
+	if len(rs) > 0 {
+		return rs
+	}
+	for _, r := range recent {
+		if !r.Timestamp.Before(from) {
+			rs = append(rs, *r)
+		}
+	}
+	return rs
+}
+
+func roomNames(hc *HouseConfig) []string {
+	seen := map[string]bool{}
+	var rv, rest []string
+	for _, n := range hc.Colorize {
+		if _, ok := hc.Rooms[n]; ok && !seen[n] {
+			rv = append(rv, n)
+			seen[n] = true
+		}
+	}
+	for n := range hc.Rooms {
+		if !seen[n] {
+			rest = append(rest, n)
+		}
+	}
+	sort.Strings(rest)
+	return append(rv, rest...)
+}
+
+func roomStatuses(c context.Context, s *site, now time.Time, units Unit) []roomStatus {
+	alldata := getReadings(c, s)
+	from := now.Add(-chartSpan)
+	hc := s.config()
+
+	var rv []roomStatus
+	for _, name := range roomNames(hc) {
+		room := hc.Rooms[name]
+		st := roomStatus{
+			Name:    name,
+			Room:    room,
+			Floor:   hc.floorOf(room),
+			State:   "unknown",
+			MinText: hc.display(room, room.Min, units),
+			MaxText: hc.display(room, room.Max, units),
+		}
+
+		if recent := alldata[room.SN]; len(recent) > 0 {
+			st.HasData = true
+			st.Latest = recent[0].Reading
+			st.LatestText = hc.display(room, st.Latest, units)
+			st.Updated = recent[0].Timestamp
+			if !st.Updated.IsZero() {
+				st.Age = humanize.RelTime(st.Updated, now, "ago", "from now")
+				st.Stale = now.Sub(st.Updated) > currentExpiry
+			}
+			switch {
+			case st.Latest < room.Min:
+				st.State = "low"
+			case st.Latest > room.Max:
+				st.State = "high"
+			default:
+				st.State = "ok"
+			}
+
+			rs := chartReadings(c, s, room, recent, from, now)
+			st.Readings = len(rs)
+			if st.Chart = newChart(room, rs, from, now); st.Chart != nil {
+				st.Chart.LowText = hc.display(room, st.Chart.Low, units)
+				st.Chart.HighText = hc.display(room, st.Chart.High, units)
+			}
+		}
+
+		rv = append(rv, st)
+	}
+	return rv
+}
+
+// HandleDashboard serves an HTML page describing every room at a
+// site.
+//
+// An optional units parameter converts readings where possible, and
+// floor shows only the named floor on the plan.
+func HandleDashboard(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	s, err := requestSite(c, r)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	floor := r.FormValue("floor")
+	floors, b, err := s.layout(floor)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+
+	now := time.Now()
+	units := ParseUnit(r.FormValue("units"))
+
+	rooms := roomStatuses(c, s, now, units)
+	at := map[string]image.Point{}
+	for _, f := range floors {
+		at[f.name] = f.at
+	}
+	for i := range rooms {
+		if p, ok := at[rooms[i].Floor]; ok {
+			rc := rooms[i].Room.Rect
+			rooms[i].Box = Rect{rc.X + p.X, rc.Y + p.Y, rc.W, rc.H}
+			rooms[i].Shown = true
+		}
+	}
+
+	var names []string
+	if fs := s.config().Floors; len(fs) > 1 {
+		for _, f := range fs {
+			names = append(names, f.Name)
+		}
+	}
+
+	q := url.Values{}
+	if units != "" {
+		q.Set("units", string(units))
+	}
+	if floor != "" {
+		q.Set("floor", floor)
+	}
+	plan := s.path() + "house.svg"
+	if len(q) > 0 {
+		plan += "?" + q.Encode()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "dashboard.html", struct {
+		Site   string
+		Plan   string
+		Floor  string
+		Floors []string
+		W, H   int
+		Units  Unit
+		Rooms  []roomStatus
+		Now    time.Time
+	}{s.name, plan, floor, names, b.Dx(), b.Dy(), units, rooms, now})
+	if err != nil {
+		log.Errorf(c, "Error rendering dashboard: %v", err)
+	}
+}
@@ -38,3 +38,19 @@ func (r Readings) Swap(i, j int) {
 func (r Readings) Sort() {
 	sort.Sort(r)
 }
+
+// Dedupe drops readings that share a Key with an earlier one and
+// sorts what's left.
+func (r Readings) Dedupe() Readings {
+	seen := make(map[string]bool, len(r))
+	rv := r[:0]
+	for _, x := range r {
+		k := x.Key()
+		if !seen[k] {
+			seen[k] = true
+			rv = append(rv, x)
+		}
+	}
+	rv.Sort()
+	return rv
+}
@@ -0,0 +1,29 @@
+package house
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	rs := Readings{
+		{"a", 1, now},
+		{"a", 2, now.Add(time.Minute)},
+		{"a", 3, now},
+		{"b", 4, now},
+		{"a", 5, now.Add(time.Minute)},
+	}.Dedupe()
+
+	if len(rs) != 3 {
+		t.Fatalf("Expected 3 readings, got %v", rs)
+	}
+	if rs[0].Reading != 2 {
+		t.Errorf("Expected newest first, got %v", rs)
+	}
+	for _, r := range rs {
+		if r.Reading == 3 || r.Reading == 5 {
+			t.Errorf("Kept a later duplicate: %v", rs)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package house
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxLineLength = 64 * 1024
+
+var (
+	errNoSerial    = errors.New("missing serial number")
+	errNoTimestamp = errors.New("missing timestamp")
+	errBadValue    = errors.New("reading must be a finite number")
+)
+
+// An inputRecord is a reading along with where it was in the request.
+type inputRecord struct {
+	Index   int
+	Reading Reading
+}
+
+// A recordError explains why one record of a batch was rejected.
+type recordError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+	Retry bool   `json:"retry,omitempty"`
+}
+
+type byIndex []recordError
+
+func (b byIndex) Len() int           { return len(b) }
+func (b byIndex) Less(i, j int) bool { return b[i].Index < b[j].Index }
+func (b byIndex) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func validate(rd Reading) error {
+	switch {
+	case rd.Serial == "":
+		return errNoSerial
+	case rd.Timestamp.IsZero():
+		return errNoTimestamp
+	case math.IsNaN(rd.Reading) || math.IsInf(rd.Reading, 0):
+		return errBadValue
+	}
+	return nil
+}
+
+// batch accumulates decoded records and the errors found along the
+// way.
+type batch struct {
+	records []inputRecord
+	errors  []recordError
+}
+
+func (b *batch) add(i int, rd Reading, err error) {
+	if err == nil {
+		err = validate(rd)
+	}
+	if err != nil {
+		b.errors = append(b.errors, recordError{Index: i, Error: err.Error()})
+		return
+	}
+	b.records = append(b.records, inputRecord{i, rd})
+}
+
+// decodeInput reads the readings out of a request according to its
+// content type:
+//
+//	application/x-www-form-urlencoded  parallel sn, ts and r values
+//	application/json                   an array of Reading objects
+//	text/plain                         InfluxDB line protocol
+//
+// Bad records are reported individually.  An error is only returned
+// when the request can't be understood at all.
+func decodeInput(r *http.Request) (*batch, error) {
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		ct = "application/x-www-form-urlencoded"
+	}
+
+	switch ct {
+	case "application/json":
+		return decodeJSON(r.Body)
+	case "text/plain", "application/x-influx-line-protocol":
+		return decodeLineProtocol(r.Body, r.URL.Query().Get("precision"), time.Now())
+	}
+	return decodeForm(r)
+}
+
+func decodeForm(r *http.Request) (*batch, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	sns := r.Form["sn"]
+	tss := r.Form["ts"]
+	rs := r.Form["r"]
+
+	if len(sns) != len(tss) || len(sns) != len(rs) {
+		return nil, errors.New("incorrect parameters")
+	}
+
+	b := &batch{}
+	for i := range sns {
+		rd, err := parseFormRecord(sns[i], tss[i], rs[i])
+		b.add(i, rd, err)
+	}
+	return b, nil
+}
+
+func parseFormRecord(sn, ts, r string) (Reading, error) {
+	f, err := strconv.ParseFloat(r, 64)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	return Reading{Reading: f, Serial: sn, Timestamp: t}, nil
+}
+
+func decodeJSON(r io.Reader) (*batch, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	b := &batch{}
+	for i, m := range raw {
+		rd := Reading{}
+		err := json.Unmarshal(m, &rd)
+		b.add(i, rd, err)
+	}
+	return b, nil
+}
+
+// precisions maps the InfluxDB precision parameter to a unit.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// decodeLineProtocol reads one reading per line.  Lines look like
+//
+//	temp,sn=10E8C214000000E4 value=21.5 1450000000000000000
+//
+// The serial number comes from an sn, serial or sensor tag, or failing
+// that the measurement name.  The value comes from a value, reading or
+// r field, or the only field there is.  The timestamp is optional.
+func decodeLineProtocol(r io.Reader, precision string, now time.Time) (*batch, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("unknown precision %q", precision)
+	}
+
+	b := &batch{}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), maxLineLength)
+	i := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rd, err := parseLine(line, unit, now)
+		b.add(i, rd, err)
+		i++
+	}
+	return b, s.Err()
+}
+
+// splitUnescaped splits s on sep, ignoring backslash-escaped
+// separators and any inside double quotes.
+func splitUnescaped(s string, sep byte) []string {
+	var rv []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				rv = append(rv, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(rv, s[start:])
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+func splitPair(s string) (string, string, error) {
+	kv := splitUnescaped(s, '=')
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("invalid key=value %q", s)
+	}
+	return unescape(kv[0]), kv[1], nil
+}
+
+func parseLine(line string, unit time.Duration, now time.Time) (Reading, error) {
+	parts := splitUnescaped(line, ' ')
+	if len(parts) < 2 || len(parts) > 3 {
+		return Reading{}, fmt.Errorf("expected measurement, fields and timestamp, got %q", line)
+	}
+
+	rd := Reading{Timestamp: now}
+
+	keys := splitUnescaped(parts[0], ',')
+	rd.Serial = unescape(keys[0])
+	for _, t := range keys[1:] {
+		k, v, err := splitPair(t)
+		if err != nil {
+			return Reading{}, err
+		}
+		switch k {
+		case "sn", "serial", "sensor":
+			rd.Serial = unescape(v)
+		}
+	}
+
+	fields := map[string]float64{}
+	var only string
+	for _, f := range splitUnescaped(parts[1], ',') {
+		k, v, err := splitPair(f)
+		if err != nil {
+			return Reading{}, err
+		}
+		x, err := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64)
+		if err != nil {
+			// Strings and booleans aren't readings.
+			continue
+		}
+		fields[k] = x
+		only = k
+	}
+
+	found := false
+	for _, k := range []string{"value", "reading", "r"} {
+		if x, ok := fields[k]; ok {
+			rd.Reading, found = x, true
+			break
+		}
+	}
+	if !found && len(fields) == 1 {
+		rd.Reading, found = fields[only], true
+	}
+	if !found {
+		return Reading{}, fmt.Errorf("no reading in %q", parts[1])
+	}
+
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return Reading{}, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		rd.Timestamp = time.Unix(0, ts*int64(unit)).UTC()
+	}
+
+	return rd, nil
+}
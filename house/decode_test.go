@@ -0,0 +1,109 @@
+package house
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		line string
+		unit time.Duration
+		exp  Reading
+	}{
+		{"10E8C214000000E4 value=21.5", time.Nanosecond,
+			Reading{"10E8C214000000E4", 21.5, now}},
+		{"temp,sn=10E8C214000000E4,room=attic reading=-3 1451703845", time.Second,
+			Reading{"10E8C214000000E4", -3, now}},
+		{`temp,serial=a\ b t=7i,note="x y" 1451703845000`, time.Millisecond,
+			Reading{"a b", 7, now}},
+		{"temp,sensor=x r=1,other=2", time.Nanosecond,
+			Reading{"x", 1, now}},
+	}
+
+	for _, test := range tests {
+		got, err := parseLine(test.line, test.unit, now)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.line, err)
+			continue
+		}
+		if got.Serial != test.exp.Serial || got.Reading != test.exp.Reading ||
+			!got.Timestamp.Equal(test.exp.Timestamp) {
+			t.Errorf("parseLine(%q) = %+v, want %+v", test.line, got, test.exp)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"justameasurement",
+		"x a=1,b=2",
+		"x value=1 notatime",
+		"x value=1 2 3",
+		"x,bogus value=1",
+	} {
+		if rd, err := parseLine(line, time.Second, time.Now()); err == nil {
+			t.Errorf("Expected error parsing %q, got %+v", line, rd)
+		}
+	}
+}
+
+func TestDecodeLineProtocol(t *testing.T) {
+	in := `# a comment
+a value=1 1451703845
+
+b value=NaN 1451703845
+c value=2 1451703845
+d nope="x"
+`
+	b, err := decodeLineProtocol(strings.NewReader(in), "s", time.Now())
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if len(b.records) != 2 || b.records[0].Index != 0 || b.records[1].Index != 2 {
+		t.Errorf("Unexpected records: %+v", b.records)
+	}
+	if len(b.errors) != 2 || b.errors[0].Index != 1 || b.errors[1].Index != 3 {
+		t.Errorf("Unexpected errors: %+v", b.errors)
+	}
+
+	if _, err := decodeLineProtocol(strings.NewReader(in), "h", time.Now()); err == nil {
+		t.Errorf("Expected error for unknown precision")
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	tests := []struct {
+		ctype, body string
+		ok, bad     int
+	}{
+		{"application/x-www-form-urlencoded",
+			"sn=a&ts=2016-01-02T03:04:05Z&r=1&sn=b&ts=2016-01-02T03:04:05Z&r=x", 1, 1},
+		{"application/json; charset=utf-8",
+			`[{"Serial":"a","Reading":1,"Timestamp":"2016-01-02T03:04:05Z"},
+			  {"Serial":"","Reading":1,"Timestamp":"2016-01-02T03:04:05Z"},
+			  {"Serial":"c","Reading":"x"},
+			  {"Serial":"d","Reading":2}]`, 1, 3},
+		{"text/plain", "a value=1\nb value=2\n", 2, 0},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/house/input/", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.ctype)
+		b, err := decodeInput(req)
+		if err != nil {
+			t.Errorf("Error decoding %v: %v", test.ctype, err)
+			continue
+		}
+		if len(b.records) != test.ok || len(b.errors) != test.bad {
+			t.Errorf("%v: got %v ok, %v bad (%+v); want %v, %v",
+				test.ctype, len(b.records), len(b.errors), b.errors, test.ok, test.bad)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package house
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// datastoreHistory keeps readings as Reading entities in the datastore.
+type datastoreHistory struct{}
+
+func (datastoreHistory) Put(c context.Context, rs []*Reading) error {
+	keys := make([]*datastore.Key, 0, len(rs))
+	for _, r := range rs {
+		keys = append(keys, datastore.NewKey(c, "Reading", r.Key(), 0, nil))
+	}
+	_, err := datastore.PutMulti(c, keys, rs)
+	return err
+}
+
+func (datastoreHistory) Range(c context.Context, sn string, from, to time.Time, limit int) (Readings, error) {
+	q := datastore.NewQuery("Reading").
+		Filter("Serial =", sn).
+		Filter("Timestamp >=", from).
+		Filter("Timestamp <", to).
+		Order("-Timestamp").
+		Limit(limit)
+
+	var rv Readings
+	_, err := q.GetAll(c, &rv)
+	return rv, err
+}
+
+func rollupKeys(c context.Context, rs []*Rollup) []*datastore.Key {
+	keys := make([]*datastore.Key, 0, len(rs))
+	for _, r := range rs {
+		keys = append(keys, datastore.NewKey(c, "Rollup", r.Key(), 0, nil))
+	}
+	return keys
+}
+
+func (datastoreHistory) LoadRollups(c context.Context, rs []*Rollup) error {
+	err := datastore.GetMulti(c, rollupKeys(c, rs), rs)
+	if me, ok := err.(appengine.MultiError); ok {
+		for _, e := range me {
+			if e != nil && e != datastore.ErrNoSuchEntity {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+func (datastoreHistory) PutRollups(c context.Context, rs []*Rollup) error {
+	_, err := datastore.PutMulti(c, rollupKeys(c, rs), rs)
+	return err
+}
+
+func (datastoreHistory) Rollups(c context.Context, sn, res string, from, to time.Time, limit int) ([]*Rollup, error) {
+	q := datastore.NewQuery("Rollup").
+		Filter("Serial =", sn).
+		Filter("Resolution =", res).
+		Filter("Start >=", from).
+		Filter("Start <", to).
+		Order("-Start").
+		Limit(limit)
+
+	var rv []*Rollup
+	_, err := q.GetAll(c, &rv)
+	return rv, err
+}
@@ -0,0 +1,303 @@
+package house
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const dayFormat = "20060102"
+
+// FileHistory keeps readings on local disk, one file of JSON lines
+// per serial number per (UTC) day.
+type FileHistory struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileHistory returns a HistoryStore rooted at the given directory.
+func NewFileHistory(dir string) *FileHistory {
+	return &FileHistory{dir: dir}
+}
+
+// serialDir is where a serial number's files live.  Escaping takes
+// care of slashes, but "", "." and ".." would still name the history
+// directory or its parent.
+func (f *FileHistory) serialDir(sn string) (string, error) {
+	switch sn {
+	case "", ".", "..":
+		return "", fmt.Errorf("invalid serial number %q", sn)
+	}
+	return filepath.Join(f.dir, url.QueryEscape(sn)), nil
+}
+
+func (f *FileHistory) path(sn string, t time.Time) (string, error) {
+	dir, err := f.serialDir(sn)
+	return filepath.Join(dir, t.UTC().Format(dayFormat)+".json"), err
+}
+
+// dayFiles lists the day files in dir covering [from, to), oldest
+// first.  Only days that have files are visited, however long the
+// range.
+func dayFiles(dir string, from, to time.Time) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	first := from.UTC().Truncate(24 * time.Hour)
+	var rv []string
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		day, err := time.Parse(dayFormat, strings.TrimSuffix(name, ".json"))
+		if err != nil || day.Before(first) || !day.Before(to) {
+			continue
+		}
+		rv = append(rv, filepath.Join(dir, name))
+	}
+	return rv, nil
+}
+
+// Put appends readings to their day files.
+func (f *FileHistory) Put(c context.Context, rs []*Reading) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	byPath := map[string][]*Reading{}
+	for _, r := range rs {
+		p, err := f.path(r.Serial, r.Timestamp)
+		if err != nil {
+			return err
+		}
+		byPath[p] = append(byPath[p], r)
+	}
+
+	for p, prs := range byPath {
+		if err := appendReadings(p, prs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendReadings(p string, rs []*Reading) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(out)
+	for _, r := range rs {
+		if err := e.Encode(r); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// Range scans the day files covering [from, to).
+func (f *FileHistory) Range(c context.Context, sn string, from, to time.Time, limit int) (Readings, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := map[string]bool{}
+	var rv Readings
+
+	dir, err := f.serialDir(sn)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := dayFiles(dir, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		in, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		s := bufio.NewScanner(in)
+		for s.Scan() {
+			r := Reading{}
+			if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+				continue
+			}
+			if r.Timestamp.Before(from) || !r.Timestamp.Before(to) || seen[r.Key()] {
+				continue
+			}
+			seen[r.Key()] = true
+			rv = append(rv, r)
+		}
+		err = s.Err()
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rv.Sort()
+	if len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv, nil
+}
+
+// storedRollup keeps the fields a rollup hides from its API form.
+type storedRollup struct {
+	*Rollup
+	Seen []int64
+}
+
+func (f *FileHistory) rollupPath(sn, res string, t time.Time) (string, error) {
+	dir, err := f.serialDir(sn)
+	return filepath.Join(dir, res, t.UTC().Format(dayFormat)+".json"), err
+}
+
+func readRollups(p string) (map[string]storedRollup, error) {
+	m := map[string]storedRollup{}
+	in, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var stored []storedRollup
+	if err := json.NewDecoder(in).Decode(&stored); err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		s.Rollup.Seen = s.Seen
+		m[s.Key()] = s
+	}
+	return m, nil
+}
+
+func writeRollups(p string, m map[string]storedRollup) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	stored := make([]storedRollup, 0, len(m))
+	for _, s := range m {
+		stored = append(stored, s)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// LoadRollups fills in rollups from their day files.
+func (f *FileHistory) LoadRollups(c context.Context, rs []*Rollup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := map[string]map[string]storedRollup{}
+	for _, r := range rs {
+		p, err := f.rollupPath(r.Serial, r.Resolution, r.Start)
+		if err != nil {
+			return err
+		}
+		m, ok := files[p]
+		if !ok {
+			m, err = readRollups(p)
+			if err != nil {
+				return err
+			}
+			files[p] = m
+		}
+		if s, ok := m[r.Key()]; ok {
+			*r = *s.Rollup
+		}
+	}
+	return nil
+}
+
+// PutRollups rewrites the day files holding the given rollups.
+func (f *FileHistory) PutRollups(c context.Context, rs []*Rollup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	byPath := map[string][]*Rollup{}
+	for _, r := range rs {
+		p, err := f.rollupPath(r.Serial, r.Resolution, r.Start)
+		if err != nil {
+			return err
+		}
+		byPath[p] = append(byPath[p], r)
+	}
+
+	for p, prs := range byPath {
+		m, err := readRollups(p)
+		if err != nil {
+			return err
+		}
+		for _, r := range prs {
+			m[r.Key()] = storedRollup{r, r.Seen}
+		}
+		if err := writeRollups(p, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollups scans the day files covering [from, to).
+func (f *FileHistory) Rollups(c context.Context, sn, res string, from, to time.Time, limit int) ([]*Rollup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir, err := f.serialDir(sn)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := dayFiles(filepath.Join(dir, res), from, to)
+	if err != nil {
+		return nil, err
+	}
+	var rv []*Rollup
+	for _, p := range paths {
+		m, err := readRollups(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range m {
+			if s.Start.Before(from) || !s.Start.Before(to) {
+				continue
+			}
+			rv = append(rv, s.Rollup)
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Start.After(rv[j].Start) })
+	if len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv, nil
+}
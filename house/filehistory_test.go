@@ -0,0 +1,121 @@
+package house
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileHistory(t *testing.T) {
+	h := NewFileHistory(t.TempDir())
+
+	base := time.Date(2016, 1, 15, 23, 58, 0, 0, time.UTC)
+	var rs []*Reading
+	for i := 0; i < 5; i++ {
+		rs = append(rs, &Reading{
+			Serial:    "attic",
+			Reading:   float64(i),
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	rs = append(rs, &Reading{Serial: "garage", Reading: 99, Timestamp: base})
+
+	if err := h.Put(nil, rs); err != nil {
+		t.Fatalf("Error storing readings: %v", err)
+	}
+	// Storing things twice shouldn't produce duplicates.
+	if err := h.Put(nil, rs[:2]); err != nil {
+		t.Fatalf("Error storing readings: %v", err)
+	}
+
+	tests := []struct {
+		from, to time.Duration
+		limit    int
+		exp      []float64
+	}{
+		{0, time.Hour, 100, []float64{4, 3, 2, 1, 0}},
+		{time.Minute, 3 * time.Minute, 100, []float64{2, 1}},
+		{0, time.Hour, 2, []float64{4, 3}},
+		{time.Hour, 2 * time.Hour, 100, nil},
+	}
+
+	for _, test := range tests {
+		got, err := h.Range(nil, "attic", base.Add(test.from), base.Add(test.to), test.limit)
+		if err != nil {
+			t.Errorf("Error fetching %v-%v: %v", test.from, test.to, err)
+			continue
+		}
+		if len(got) != len(test.exp) {
+			t.Errorf("Expected %v for %v-%v, got %v", test.exp, test.from, test.to, got)
+			continue
+		}
+		for i, r := range got {
+			if r.Reading != test.exp[i] || r.Serial != "attic" {
+				t.Errorf("Expected %v for %v-%v, got %v", test.exp, test.from, test.to, got)
+				break
+			}
+		}
+	}
+
+	// From the beginning of time only visits the days on disk.
+	got, err := h.Range(nil, "attic", time.Time{}, base.Add(time.Hour), 100)
+	if err != nil || len(got) != 5 {
+		t.Errorf("Expected everything since forever, got %v, %v", got, err)
+	}
+	if got, err := h.Range(nil, "cellar", time.Time{}, base, 100); err != nil || len(got) != 0 {
+		t.Errorf("Expected nothing for an unknown serial, got %v, %v", got, err)
+	}
+}
+
+func TestDayFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"20160114.json", "20160115.json", "20160116.json",
+		"20160115.json.tmp", "notes.json", "20160117.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "20160118.json"), 0755)
+
+	from := time.Date(2016, 1, 15, 12, 0, 0, 0, time.UTC)
+	got, err := dayFiles(dir, from, from.Add(2*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{filepath.Join(dir, "20160115.json"), filepath.Join(dir, "20160116.json"),
+		filepath.Join(dir, "20160117.json")}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+
+	if got, err := dayFiles(filepath.Join(dir, "missing"), time.Time{}, from); got != nil || err != nil {
+		t.Errorf("Expected nothing from a missing directory, got %v, %v", got, err)
+	}
+}
+
+func TestFileHistoryBadSerial(t *testing.T) {
+	dir := t.TempDir()
+	h := NewFileHistory(filepath.Join(dir, "history"))
+	ts := time.Date(2016, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	for _, sn := range []string{"", ".", ".."} {
+		if err := h.Put(nil, []*Reading{{Serial: sn, Timestamp: ts}}); err == nil {
+			t.Errorf("Expected an error storing serial %q", sn)
+		}
+		if _, err := h.Range(nil, sn, time.Time{}, ts, 100); err == nil {
+			t.Errorf("Expected an error reading serial %q", sn)
+		}
+		if err := h.PutRollups(nil, []*Rollup{{Serial: sn, Resolution: "1h", Start: ts}}); err == nil {
+			t.Errorf("Expected an error storing rollups for serial %q", sn)
+		}
+		if _, err := h.Rollups(nil, sn, "1h", time.Time{}, ts, 100); err == nil {
+			t.Errorf("Expected an error reading rollups for serial %q", sn)
+		}
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		t.Errorf("Expected nothing written, got %v", fis)
+	}
+}
@@ -0,0 +1,149 @@
+package house
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+func (r Rect) rectangle() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H)
+}
+
+// polygonBounds is the smallest Rect holding every point.
+func polygonBounds(pts []Point) Rect {
+	if len(pts) == 0 {
+		return Rect{}
+	}
+	x0, y0, x1, y1 := pts[0].X, pts[0].Y, pts[0].X, pts[0].Y
+	for _, p := range pts[1:] {
+		if p.X < x0 {
+			x0 = p.X
+		}
+		if p.X > x1 {
+			x1 = p.X
+		}
+		if p.Y < y0 {
+			y0 = p.Y
+		}
+		if p.Y > y1 {
+			y1 = p.Y
+		}
+	}
+	return Rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+// inside reports whether the middle of the pixel at x, y is part of
+// the room.
+func (r *Room) inside(x, y int) bool {
+	if len(r.Polygon) == 0 {
+		return image.Pt(x, y).In(r.Rect.rectangle())
+	}
+
+	// Even-odd rule: count the edges a ray to the right crosses.
+	px, py := float64(x)+0.5, float64(y)+0.5
+	in := false
+	pts := r.Polygon
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		xi, yi := float64(pts[i].X), float64(pts[i].Y)
+		xj, yj := float64(pts[j].X), float64(pts[j].Y)
+		if (yi > py) != (yj > py) && px < (xj-xi)*(py-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// mask is the room's shape over its Rect.
+func (r *Room) mask() *image.Alpha {
+	b := r.Rect.rectangle()
+	m := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r.inside(x, y) {
+				m.SetAlpha(x, y, color.Alpha{255})
+			}
+		}
+	}
+	return m
+}
+
+// outline is the room's shape grown by a pixel all round.  Painting
+// it before filling the room leaves a border.
+func (r *Room) outline() *image.Alpha {
+	b := r.Rect.rectangle().Inset(-1)
+	m := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+		near:
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if r.inside(x+dx, y+dy) {
+						m.SetAlpha(x, y, color.Alpha{255})
+						break near
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+// center is the middle of the room: the middle of its Rect, or for a
+// polygon the point inside it nearest its centroid.
+func (r *Room) center() (x, y int) {
+	x, y = r.Rect.X+(r.Rect.W/2), r.Rect.Y+(r.Rect.H/2)
+	if len(r.Polygon) == 0 {
+		return
+	}
+
+	// Area weighted centroid.
+	var a, cx, cy float64
+	pts := r.Polygon
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		xi, yi := float64(pts[i].X), float64(pts[i].Y)
+		xj, yj := float64(pts[j].X), float64(pts[j].Y)
+		cross := xj*yi - xi*yj
+		a += cross
+		cx += (xj + xi) * cross
+		cy += (yj + yi) * cross
+	}
+	if a != 0 {
+		x, y = int(cx/(3*a)), int(cy/(3*a))
+	}
+	if r.inside(x, y) {
+		return
+	}
+
+	best := math.MaxFloat64
+	tx, ty := x, y
+	b := r.Rect.rectangle()
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		for px := b.Min.X; px < b.Max.X; px++ {
+			dx, dy := float64(px-tx), float64(py-ty)
+			if d := dx*dx + dy*dy; d < best && r.inside(px, py) {
+				best, x, y = d, px, py
+			}
+		}
+	}
+	return
+}
+
+// overlaps reports whether two rooms share any pixels.
+func (r *Room) overlaps(o *Room) bool {
+	common := r.Rect.rectangle().Intersect(o.Rect.rectangle())
+	if common.Empty() {
+		return false
+	}
+	if len(r.Polygon) == 0 && len(o.Polygon) == 0 {
+		return true
+	}
+	for y := common.Min.Y; y < common.Max.Y; y++ {
+		for x := common.Min.X; x < common.Max.X; x++ {
+			if r.inside(x, y) && o.inside(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
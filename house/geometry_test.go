@@ -0,0 +1,69 @@
+package house
+
+import "testing"
+
+// lRoom is an L: a 30 wide column down the left and a 30 high bar
+// along the top.
+func lRoom() *Room {
+	pts := []Point{{0, 0}, {100, 0}, {100, 30}, {30, 30}, {30, 100}, {0, 100}}
+	return &Room{Polygon: pts, Rect: polygonBounds(pts)}
+}
+
+func TestPolygonInside(t *testing.T) {
+	r := lRoom()
+	if r.Rect != (Rect{0, 0, 100, 100}) {
+		t.Errorf("Unexpected bounds: %+v", r.Rect)
+	}
+
+	tests := []struct {
+		x, y int
+		exp  bool
+	}{
+		{0, 0, true},
+		{99, 0, true},
+		{100, 0, false},
+		{29, 99, true},
+		{30, 99, false},
+		{50, 50, false},
+		{50, 29, true},
+		{-1, 5, false},
+	}
+	for _, test := range tests {
+		if got := r.inside(test.x, test.y); got != test.exp {
+			t.Errorf("inside(%v, %v) = %v, want %v", test.x, test.y, got, test.exp)
+		}
+	}
+
+	rc := &Room{Rect: Rect{10, 10, 5, 5}}
+	if !rc.inside(14, 14) || rc.inside(15, 14) {
+		t.Errorf("Rect rooms should cover exactly their Rect")
+	}
+}
+
+func TestPolygonCenter(t *testing.T) {
+	// The centroid of the L is outside it, so the label goes to the
+	// nearest point inside.
+	r := lRoom()
+	x, y := r.center()
+	if !r.inside(x, y) {
+		t.Errorf("Center %v,%v is outside the room", x, y)
+	}
+
+	rc := &Room{Rect: Rect{10, 10, 20, 40}}
+	if x, y := rc.center(); x != 20 || y != 30 {
+		t.Errorf("Expected a rect centered at 20,30, got %v,%v", x, y)
+	}
+}
+
+func TestPolygonOverlaps(t *testing.T) {
+	r := lRoom()
+	// Sits in the notch of the L, which its bounds overlap.
+	notch := &Room{Rect: Rect{40, 40, 50, 50}}
+	if r.overlaps(notch) || notch.overlaps(r) {
+		t.Errorf("Room in the notch shouldn't overlap")
+	}
+	corner := &Room{Rect: Rect{25, 25, 10, 10}}
+	if !r.overlaps(corner) {
+		t.Errorf("Room at the corner should overlap")
+	}
+}
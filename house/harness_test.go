@@ -0,0 +1,286 @@
+package house
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+const testDevice = "harness"
+
+// harness runs the house handlers against in-memory platform services
+// and a scratch directory, serving the real static files for sites
+// to load.
+type harness struct {
+	t     *testing.T
+	srv   *httptest.Server
+	dir   string
+	queue *platform.MemoryQueue
+	token string
+}
+
+func newHarness(t *testing.T) *harness {
+	dir, err := ioutil.TempDir("", "house")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("../static"))))
+	mux.HandleFunc("/house/", Server)
+	mux.HandleFunc("/house/house.svg", ServeSVG)
+	mux.HandleFunc("/house/api/current", HandleAPICurrent)
+	mux.HandleFunc("/house/api/readings", HandleAPIReadings)
+	mux.HandleFunc("/house/input/", HandleInput)
+	mux.HandleFunc("/cron/house/consume/", ConsumeInput)
+
+	h := &harness{t: t, srv: httptest.NewServer(mux), dir: dir}
+
+	s := platform.Local(dir, strings.TrimPrefix(h.srv.URL, "http://"), mux)
+	if !testing.Verbose() {
+		s.Log = func(context.Context, platform.Level, string, ...interface{}) {}
+	}
+	h.queue = s.Queue.(*platform.MemoryQueue)
+	// Input only gets consumed when a test asks, not whenever a post
+	// happens to trigger it in the background.
+	h.queue.Handler = http.NotFoundHandler()
+	platform.Use(s)
+	SetHistoryStore(NewFileHistory(filepath.Join(dir, "history")))
+
+	resetSites()
+	c := context.Background()
+	houseInit(c)
+	// Without a font labels aren't drawn, keeping renderings
+	// independent of the rasterizer.
+	font = nil
+
+	d := &Device{Name: testDevice, Secret: "sekrit", Serials: []string{"*"}}
+	if err := saveDevice(c, d); err != nil {
+		t.Fatalf("Error creating device: %v", err)
+	}
+	h.token = d.Token()
+
+	return h
+}
+
+func (h *harness) Close() {
+	resetSites()
+	h.srv.Close()
+	os.RemoveAll(h.dir)
+}
+
+// resetSites forgets every site so the next test loads its own.
+func resetSites() {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	sites = map[string]*site{}
+	siteNames = nil
+}
+
+func (h *harness) do(method, path, ctype, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, h.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if ctype != "" {
+		req.Header.Set("Content-Type", ctype)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("Error on %v %v: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		h.t.Fatalf("Error reading %v %v: %v", method, path, err)
+	}
+	return res, data
+}
+
+// post sends line protocol readings, expecting them all to be taken.
+func (h *harness) post(lines string) {
+	res, body := h.do("POST", "/house/input/", "text/plain", lines)
+	if res.StatusCode != 202 {
+		h.t.Fatalf("Error posting readings: %v\n%s", res.Status, body)
+	}
+}
+
+// consume drains the input queue.
+func (h *harness) consume() {
+	res, body := h.do("POST", "/cron/house/consume/", "", "")
+	if res.StatusCode != 204 {
+		h.t.Fatalf("Error consuming input: %v\n%s", res.Status, body)
+	}
+	if n := h.queue.Len(readingQueue); n != 0 {
+		h.t.Fatalf("Expected an empty queue after consuming, have %v", n)
+	}
+}
+
+func (h *harness) getPNG(path string) image.Image {
+	res, body := h.do("GET", path, "", "")
+	if res.StatusCode != 200 {
+		h.t.Fatalf("Error getting %v: %v\n%s", path, res.Status, body)
+	}
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatalf("Error decoding %v: %v", path, err)
+	}
+	return img
+}
+
+// compareGolden checks an image against testdata/name pixel by pixel.
+func compareGolden(t *testing.T, name string, got image.Image) {
+	fn := filepath.Join("testdata", name)
+	if *updateGolden {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("Error opening golden file (run with -update to create it): %v", err)
+	}
+	defer f.Close()
+	exp, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("Error decoding %v: %v", fn, err)
+	}
+
+	if exp.Bounds() != got.Bounds() {
+		t.Fatalf("Expected bounds %v, got %v", exp.Bounds(), got.Bounds())
+	}
+	diffs := 0
+	b := exp.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			er, eg, eb, ea := exp.At(x, y).RGBA()
+			gr, gg, gb, ga := got.At(x, y).RGBA()
+			if er != gr || eg != gg || eb != gb || ea != ga {
+				if diffs < 5 {
+					t.Errorf("Pixel %v,%v: expected %v, got %v", x, y, exp.At(x, y), got.At(x, y))
+				}
+				diffs++
+			}
+		}
+	}
+	if diffs > 0 {
+		t.Errorf("%v pixels differ from %v (run with -update if this is intended)", diffs, fn)
+	}
+}
+
+// testReadings produces readings for the garage and the living room
+// with enough history to draw sparklines, and one for the bedroom
+// that's too cold.
+func testReadings() string {
+	base := time.Date(2016, 1, 2, 3, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	for i := 0; i < 60; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute).UnixNano()
+		fmt.Fprintf(buf, "temp,sn=10E8C214000000E4 value=%v %v\n", 15+float64(i%20)/2, ts)
+		fmt.Fprintf(buf, "temp,sn=10C8892A00000096 value=%v %v\n", 22-float64(i)/10, ts)
+	}
+	fmt.Fprintf(buf, "temp,sn=1081841E000000DF value=5 %v\n", base.UnixNano())
+	return buf.String()
+}
+
+func TestAtomicInput(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	// The second line is missing its value.
+	lines := "temp,sn=10E8C214000000E4 value=15 1451703600000000000\n" +
+		"temp,sn=10C8892A00000096 1451703600000000000\n"
+	tests := []struct {
+		query, body string
+		status      int
+	}{
+		{"?atomic=maybe", "Invalid atomic value", 400},
+		{"?atomic=1", "batch rejected", 400},
+		{"?atomic=true", "batch rejected", 400},
+		{"?atomic=0", `"accepted":[0]`, 207},
+		{"?atomic=false", `"accepted":[0]`, 207},
+		{"", `"accepted":[0]`, 207},
+	}
+	for _, test := range tests {
+		res, body := h.do("POST", "/house/input/"+test.query, "text/plain", lines)
+		if res.StatusCode != test.status || !bytes.Contains(body, []byte(test.body)) {
+			t.Errorf("%q: got %v, want %v\n%s", test.query, res.Status, test.status, body)
+		}
+	}
+}
+
+func TestHistoryWithColdCache(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	h.post(testReadings())
+	h.consume()
+	platform.Default.Cache.Delete(context.Background(), defaultSite().key("current"))
+
+	res, body := h.do("GET", "/house/api/readings?from=2016-01-02T00:00:00Z&to=2016-01-03T00:00:00Z", "", "")
+	if res.StatusCode != 200 || !bytes.Contains(body, []byte(`"serial":"10E8C214000000E4"`)) {
+		t.Errorf("Expected history without the cache, got %v\n%.200s", res.Status, body)
+	}
+}
+
+func TestDashboardChart(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+	c := context.Background()
+
+	now := time.Now()
+	buf := &bytes.Buffer{}
+	for i := 0; i < 180; i++ {
+		ts := now.Add(time.Duration(i-180) * time.Minute).UnixNano()
+		fmt.Fprintf(buf, "temp,sn=10E8C214000000E4 value=%v %v\n", 15+float64(i%20)/2, ts)
+	}
+	h.post(buf.String())
+	h.consume()
+
+	s := defaultSite()
+	if err := s.refresh(c); err != nil {
+		t.Fatal(err)
+	}
+	room := s.config().BySerial("10E8C214000000E4")
+	rs := chartReadings(c, s, room, nil, now.Add(-chartSpan), now)
+	// One point per hour touched, not per reading.
+	if len(rs) < 3 || len(rs) > 4 {
+		t.Errorf("Expected hourly points, got %v", rs)
+	}
+}
+
+func TestIngestToRender(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	readings := testReadings()
+	h.post(readings)
+	// A retried post shouldn't show up twice in the sparklines.
+	h.post(readings)
+	h.consume()
+
+	compareGolden(t, "house.png", h.getPNG("/house/"))
+}
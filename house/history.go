@@ -0,0 +1,157 @@
+package house
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+const (
+	defaultRangeItems = 1000
+	maxRangeItems     = 10000
+	defaultRangeSpan  = time.Hour * 24
+)
+
+// A HistoryStore durably records readings and their rollups and
+// answers time range queries against them.  Serial numbers are
+// namespaced to their site with site.key.
+type HistoryStore interface {
+	// Put records the given readings.  Storing the same reading
+	// twice must be harmless.
+	Put(c context.Context, rs []*Reading) error
+	// Range returns up to limit readings for a serial number in
+	// [from, to), newest first.
+	Range(c context.Context, sn string, from, to time.Time, limit int) (Readings, error)
+
+	// LoadRollups fills in any stored values for the given
+	// rollups, identified by Serial, Resolution and Start.
+	// Rollups that haven't been stored are left alone.
+	LoadRollups(c context.Context, rs []*Rollup) error
+	// PutRollups stores the given rollups, replacing what was there.
+	PutRollups(c context.Context, rs []*Rollup) error
+	// Rollups returns up to limit rollups of a resolution for a
+	// serial number starting in [from, to), newest first.
+	Rollups(c context.Context, sn, res string, from, to time.Time, limit int) ([]*Rollup, error)
+}
+
+// ErrNoReadings is returned when a sensor hasn't reported anything.
+var ErrNoReadings = errors.New("no readings")
+
+var history = historyFromEnv()
+
+// historyFromEnv picks a history store.  Setting HOUSE_HISTORY_DIR
+// keeps history on local disk, otherwise it goes to the datastore.
+func historyFromEnv() HistoryStore {
+	if d := os.Getenv("HOUSE_HISTORY_DIR"); d != "" {
+		return NewFileHistory(d)
+	}
+	return datastoreHistory{}
+}
+
+// SetHistoryStore replaces the store readings are persisted to.
+func SetHistoryStore(h HistoryStore) {
+	history = h
+}
+
+func parseTimeParam(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseRange pulls the from, to and limit parameters out of a request.
+func parseRange(r *http.Request) (from, to time.Time, limit int, err error) {
+	to, err = parseTimeParam(r.FormValue("to"), time.Now())
+	if err != nil {
+		return
+	}
+	from, err = parseTimeParam(r.FormValue("from"), to.Add(-defaultRangeSpan))
+	if err != nil {
+		return
+	}
+	limit = defaultRangeItems
+	if l := r.FormValue("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return
+		}
+	}
+	if limit <= 0 || limit > maxRangeItems {
+		limit = maxRangeItems
+	}
+	return
+}
+
+// HandleHistory serves stored readings for a serial number between
+// two timestamps.
+//
+// Parameters are sn, from and to (RFC3339, defaulting to the last
+// day), an optional limit and units as for HandleAPICurrent.
+func HandleHistory(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	s, err := requestSite(c, r)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	hc := s.config()
+
+	sn := r.FormValue("sn")
+	if sn == "" {
+		showError(c, w, "Missing sn", 400)
+		return
+	}
+
+	from, to, limit, err := parseRange(r)
+	if err != nil {
+		showError(c, w, "Invalid range: "+err.Error(), 400)
+		return
+	}
+
+	rs, err := history.Range(c, s.key(sn), from, to, limit)
+	if err != nil {
+		showError(c, w, "Error fetching history: "+err.Error(), 500)
+		return
+	}
+	if rs == nil {
+		rs = Readings{}
+	}
+	u := hc.UnitOf(hc.BySerial(sn))
+	units := ParseUnit(r.FormValue("units"))
+	for i := range rs {
+		rs[i].Serial = sn
+		rs[i].Reading, _ = u.Convert(rs[i].Reading, units)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rs)
+}
+
+// LatestReading returns the most recent reading for a serial number,
+// looking in cache first and then in history as far back as maxAge.
+func LatestReading(c context.Context, sn string, maxAge time.Duration) (Reading, error) {
+	s := siteOf(allSites(c), sn)
+	var rs Readings
+	if err := platform.GetJSON(c, s.key("r-"+sn), &rs); err == nil && len(rs) > 0 {
+		return rs[0], nil
+	}
+
+	now := time.Now()
+	rs, err := history.Range(c, s.key(sn), now.Add(-maxAge), now, 1)
+	if err != nil {
+		return Reading{}, err
+	}
+	if len(rs) == 0 {
+		return Reading{}, ErrNoReadings
+	}
+	rs[0].Serial = sn
+	return rs[0], nil
+}
@@ -12,15 +12,15 @@ import (
 	"io/ioutil"
 	"math"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"golang.org/x/net/context"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
-	"google.golang.org/appengine/urlfetch"
+	"platform"
+	"platform/log"
 
 	// Required for generating PNGs
 	_ "image/png"
@@ -32,18 +32,16 @@ import (
 
 const (
 	houseImgKey = "houseimg"
-	houseExpKey = "houseexp"
+	houseSVGKey = "housesvg"
 )
 
 var (
 	font          *truetype.Font
-	houseBase     image.Image
-	conf          HouseConfig
 	houseInitOnce sync.Once
 )
 
 func mustFetch(c context.Context, u string) io.ReadCloser {
-	client := urlfetch.Client(c)
+	client := platform.HTTPClient(c)
 	res, err := client.Get(u)
 	must(err)
 	if res.StatusCode != 200 {
@@ -52,45 +50,29 @@ func mustFetch(c context.Context, u string) io.ReadCloser {
 	return res.Body
 }
 
+// houseInit loads the font everything is labeled with and brings
+// every site up to date.
 func houseInit(c context.Context) {
 	houseInitOnce.Do(func() {
-		log.Infof(c, "Initializing all the house things on "+appengine.InstanceID())
-
-		base := "http://" + appengine.DefaultVersionHostname(c) + "/static/house/"
-
-		wg := &sync.WaitGroup{}
-		wg.Add(3)
-
-		go func() {
-			defer wg.Done()
-			fontr := mustFetch(c, base+"luximr.ttf")
-			defer fontr.Close()
-			fontBytes, err := ioutil.ReadAll(fontr)
-			must(err)
-			font, err = freetype.ParseFont(fontBytes)
-			must(err)
-		}()
-
-		go func() {
-			defer wg.Done()
-			pngr := mustFetch(c, base+"house.png")
-			defer pngr.Close()
-			var err error
-			houseBase, _, err = image.Decode(pngr)
-			must(err)
-		}()
-
-		go func() {
-			defer wg.Done()
-			confr := mustFetch(c, base+"house.json")
-			d := json.NewDecoder(confr)
-			must(d.Decode(&conf))
-		}()
-		wg.Wait()
+		log.Infof(c, "Initializing all the house things on %v", platform.Hostname(c))
+
+		fontr := mustFetch(c, "http://"+platform.Hostname(c)+"/static/house/luximr.ttf")
+		defer fontr.Close()
+		fontBytes, err := ioutil.ReadAll(fontr)
+		must(err)
+		font, err = freetype.ParseFont(fontBytes)
+		must(err)
 	})
+	allSites(c)
 }
 
 func drawBox(i *image.NRGBA, room *Room) {
+	if len(room.Polygon) > 0 {
+		o := room.outline()
+		draw.DrawMask(i, o.Bounds(), image.NewUniform(color.Black), image.ZP,
+			o, o.Bounds().Min, draw.Over)
+		return
+	}
 	draw.Draw(i, image.Rect(room.Rect.X-1, room.Rect.Y-1,
 		room.Rect.X+room.Rect.W+1,
 		room.Rect.Y+room.Rect.H+1),
@@ -130,16 +112,20 @@ func getFillColor(room *Room, reading, relevance float64) (rv color.NRGBA) {
 	return
 }
 
-func fillGradient(img *image.NRGBA, room *Room, reading float64) {
-	tx := ifZero(room.Therm.X, room.Rect.X+(room.Rect.W/2))
-	ty := ifZero(room.Therm.Y, room.Rect.Y+(room.Rect.H/2))
+func fillGradient(img *image.NRGBA, hc *HouseConfig, room *Room, reading float64) {
+	cx, cy := room.center()
+	tx := ifZero(room.Therm.X, cx)
+	ty := ifZero(room.Therm.Y, cy)
 
 	for i := 0; i < room.Rect.W; i++ {
 		for j := 0; j < room.Rect.H; j++ {
 			px, py := room.Rect.X+i, room.Rect.Y+j
+			if !room.inside(px, py) {
+				continue
+			}
 			xd, yd := float64(px-tx), float64(py-ty)
 			distance := math.Sqrt(xd*xd + yd*yd)
-			relevance := 1.0 - (distance / conf.MaxRelevantDistance)
+			relevance := 1.0 - (distance / hc.MaxRelevantDistance)
 			if relevance < 0 {
 				relevance = 0
 			}
@@ -150,6 +136,12 @@ func fillGradient(img *image.NRGBA, room *Room, reading float64) {
 }
 
 func fillSolid(i *image.NRGBA, room *Room, c color.Color) {
+	if len(room.Polygon) > 0 {
+		m := room.mask()
+		draw.DrawMask(i, m.Bounds(), image.NewUniform(c), image.ZP,
+			m, m.Bounds().Min, draw.Over)
+		return
+	}
 	draw.Draw(i, image.Rect(room.Rect.X, room.Rect.Y,
 		room.Rect.X+room.Rect.W,
 		room.Rect.Y+room.Rect.H),
@@ -158,25 +150,36 @@ func fillSolid(i *image.NRGBA, room *Room, c color.Color) {
 		draw.Over)
 }
 
-func fill(i *image.NRGBA, room *Room, reading float64) {
+func fill(i *image.NRGBA, hc *HouseConfig, room *Room, reading float64) {
 	switch {
 	case reading < room.Min:
 		fillSolid(i, room, color.NRGBA{0, 0, 255, 255})
 	case reading > room.Max:
 		fillSolid(i, room, color.NRGBA{255, 0, 0, 255})
 	default:
-		fillGradient(i, room, reading)
+		fillGradient(i, hc, room, reading)
 	}
 }
 
-func drawLabel(i draw.Image, room *Room, lbl string) {
+// labelPos finds where a label's top left corner goes in a room.
+func labelPos(room *Room, lbl string) (x, y int) {
 	charwidth := 6
 	charheight := 12
 
-	x := ifZero(room.Reading.X, (room.Rect.X + (room.Rect.W / 2) -
-		((len(lbl) * charwidth) / 2)))
-	y := ifZero(room.Reading.Y, (room.Rect.Y +
+	cx, cy := room.center()
+	x = ifZero(room.Reading.X, cx-((utf8.RuneCountInString(lbl)*charwidth)/2))
+	if len(room.Polygon) > 0 {
+		y = ifZero(room.Reading.Y, cy-charheight-12)
+		return
+	}
+	y = ifZero(room.Reading.Y, (room.Rect.Y +
 		((room.Rect.H - charheight*2) / 2) - 12))
+	return
+}
+
+func drawLabel(i draw.Image, room *Room, lbl string) {
+	charheight := 12
+	x, y := labelPos(room, lbl)
 
 	c := freetype.NewContext()
 	c.SetDPI(72)
@@ -190,10 +193,12 @@ func drawLabel(i draw.Image, room *Room, lbl string) {
 	c.DrawString(lbl, pt)
 }
 
-func drawSparklines(i *image.NRGBA, room *Room, roomReadings []*Reading) {
+// sparkPoints lays out a room's readings (newest first) as sparkline
+// points, oldest on the left.
+func sparkPoints(room *Room, roomReadings []*Reading) []image.Point {
 	// Not interested in plotting fewer than two points
 	if len(roomReadings) < 2 {
-		return
+		return nil
 	}
 
 	if len(roomReadings) > room.SparkWidth() {
@@ -217,6 +222,7 @@ func drawSparklines(i *image.NRGBA, room *Room, roomReadings []*Reading) {
 		high = avg - (float64(sparkh) / 2.0)
 	}
 
+	rv := make([]image.Point, 0, len(roomReadings))
 	for pos, r := range roomReadings {
 		x := len(roomReadings) - pos + sparkx - 1
 		heightPercent := (r.Reading - low) / (high - low)
@@ -228,7 +234,14 @@ func drawSparklines(i *image.NRGBA, room *Room, roomReadings []*Reading) {
 			y = sparky
 		}
 
-		i.Set(x, y, color.Gray{127})
+		rv = append(rv, image.Pt(x, y))
+	}
+	return rv
+}
+
+func drawSparklines(i *image.NRGBA, room *Room, roomReadings []*Reading) {
+	for _, p := range sparkPoints(room, roomReadings) {
+		i.Set(p.X, p.Y, color.Gray{127})
 	}
 }
 
@@ -238,11 +251,13 @@ func must(err error) {
 	}
 }
 
-func getReadings(c context.Context) map[string][]*Reading {
+// getReadings returns the cached recent readings from every sensor at
+// a site, newest first.
+func getReadings(c context.Context, s *site) map[string][]*Reading {
 	rv := map[string][]*Reading{}
 
 	current := map[string]float64{}
-	_, err := memcache.JSON.Get(c, "current", &current)
+	err := platform.GetJSON(c, s.key("current"), &current)
 	if err != nil {
 		log.Warningf(c, "Couldn't get current values from cache: %v", err)
 		return rv
@@ -250,9 +265,9 @@ func getReadings(c context.Context) map[string][]*Reading {
 	keys := []string{}
 
 	for k := range current {
-		keys = append(keys, "r-"+k)
+		keys = append(keys, s.key("r-"+k))
 	}
-	cached, err := memcache.GetMulti(c, keys)
+	cached, err := platform.Default.Cache.GetMulti(c, keys)
 	if err != nil {
 		log.Warningf(c, "Couldn't get latest readings from cache: %v", err)
 		for k, v := range current {
@@ -261,68 +276,170 @@ func getReadings(c context.Context) map[string][]*Reading {
 		return rv
 	}
 
-	for k, vitem := range cached {
-		v := []*Reading{}
-		must(json.Unmarshal(vitem.Value, &v))
-		rv[k[2:]] = v
+	for k := range current {
+		if vitem, ok := cached[s.key("r-"+k)]; ok {
+			v := []*Reading{}
+			must(json.Unmarshal(vitem.Value, &v))
+			rv[k] = v
+		}
 	}
 	return rv
 }
 
-func drawHouse(c context.Context) image.Image {
-	i := image.NewNRGBA(houseBase.Bounds())
+// sparkData picks what to plot for a room.  With no span it's the most
+// recent raw readings, otherwise the rollups covering the span.
+func sparkData(c context.Context, s *site, room *Room, recent []*Reading, span time.Duration) []*Reading {
+	if span <= 0 {
+		return recent
+	}
+	rv, err := sparkSeries(c, s, room, span)
+	if err != nil {
+		log.Warningf(c, "Error getting %v rollups for %v: %v", span, room.SN, err)
+		return recent
+	}
+	return rv
+}
 
-	alldata := getReadings(c)
+// renderOpts are the knobs a request may turn on a house rendering.
+type renderOpts struct {
+	// span plots sparklines from rollups covering this much time
+	// instead of the latest raw readings.
+	span time.Duration
+	// units readings are displayed in.
+	units Unit
+	// floor limits the drawing to one floor instead of all of them
+	// side by side.
+	floor string
+}
 
-	draw.Draw(i, houseBase.Bounds(), houseBase, image.Pt(0, 0), draw.Over)
+func parseRenderOpts(req *http.Request) (opts renderOpts, err error) {
+	if s := req.FormValue("span"); s != "" {
+		opts.span, err = time.ParseDuration(s)
+		if err != nil {
+			return
+		}
+	}
+	opts.units = ParseUnit(req.FormValue("units"))
+	opts.floor = req.FormValue("floor")
+	return
+}
 
-	for _, roomName := range conf.Colorize {
-		room := conf.Rooms[roomName]
-		drawBox(i, room)
+// cacheSuffix distinguishes cached renderings with different options.
+func (o renderOpts) cacheSuffix() string {
+	rv := ""
+	if o.span > 0 {
+		rv += "-" + o.span.String()
+	}
+	if o.units != "" {
+		rv += "-" + string(o.units)
+	}
+	if o.floor != "" {
+		rv += "-floor=" + o.floor
+	}
+	return rv
+}
+
+// renderHouse draws every colorized room on one floor with the given
+// renderer.
+func renderHouse(c context.Context, s *site, rr renderer,
+	alldata map[string][]*Reading, floor string, opts renderOpts) {
+
+	hc := s.config()
+	for _, roomName := range hc.Colorize {
+		room := hc.Rooms[roomName]
+		if hc.floorOf(room) != floor {
+			continue
+		}
+		rr.drawBox(room)
 		roomReadings, ok := alldata[room.SN]
 		if ok {
 			reading := roomReadings[0].Reading
-			fill(i, room, reading)
-			drawLabel(i, room, fmt.Sprintf("%.2f", reading))
-			drawSparklines(i, room, roomReadings)
+			lbl := hc.display(room, reading, opts.units)
+			rr.fill(room, reading)
+			rr.drawLabel(room, lbl)
+			rr.drawSparklines(room, sparkData(c, s, room, roomReadings, opts.span))
+			rr.tooltip(room, roomName+": "+lbl)
 		} else {
-			fillSolid(i, room, color.White)
-			drawLabel(i, room, "??.??")
+			rr.fillSolid(room, color.White)
+			rr.drawLabel(room, "??.??")
+			rr.tooltip(room, roomName+": no data")
 		}
 	}
+}
+
+// drawHouse draws each floor over its plan, then puts the floors
+// together.
+func drawHouse(c context.Context, s *site, opts renderOpts) image.Image {
+	hc := s.config()
+	alldata := getReadings(c, s)
+	floors, bounds, _ := s.layout(opts.floor)
+
+	i := image.NewNRGBA(bounds)
+	for _, f := range floors {
+		b := f.plan.Bounds()
+		fi := image.NewNRGBA(b)
+		draw.Draw(fi, b, f.plan, b.Min, draw.Over)
+
+		renderHouse(c, s, &pngRenderer{fi, hc}, alldata, f.name, opts)
+
+		draw.Draw(i, b.Add(f.at), fi, b.Min, draw.Src)
+	}
 
 	return i
 }
 
-// Server is the main entry point to the house thermometer server.
-func Server(w http.ResponseWriter, req *http.Request) {
-	c := appengine.NewContext(req)
+// serveHouse serves a rendering of a site, caching it for a few
+// minutes under the given name.
+//
+// An optional span parameter (e.g. 168h) plots sparklines from rollups
+// covering that much time instead of the latest raw readings, units
+// (e.g. F) converts the labels, and floor draws only the named floor.
+func serveHouse(w http.ResponseWriter, req *http.Request, ctype, name string,
+	render func(c context.Context, s *site, opts renderOpts) []byte) {
+
+	c := platform.NewContext(req)
 
-	caches, err := memcache.GetMulti(c, []string{houseImgKey, houseExpKey})
+	opts, err := parseRenderOpts(req)
+	if err != nil {
+		showError(c, w, "Invalid options: "+err.Error(), 400)
+		return
+	}
+
+	s, err := requestSite(c, req)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	if _, _, err := s.layout(opts.floor); err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+
+	imgKey, expKey := s.key(name+opts.cacheSuffix()), s.key(name+"exp"+opts.cacheSuffix())
+
+	caches, err := platform.Default.Cache.GetMulti(c, []string{imgKey, expKey})
 	if err != nil {
 		log.Warningf(c, "Error getting stuff from memcache: %v", err)
-		caches = map[string]*memcache.Item{}
+		caches = map[string]*platform.CacheItem{}
 	}
 
 	if len(caches) == 2 {
-		data := caches[houseImgKey].Value
+		data := caches[imgKey].Value
 
 		// Serve from cache
-		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Type", ctype)
 		w.Header().Set("Cache-Control", "max-age=300")
-		w.Header().Set("Expires", string(caches[houseExpKey].Value))
+		w.Header().Set("Expires", string(caches[expKey].Value))
 		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
 
 		w.WriteHeader(200)
-		w.Write(caches[houseImgKey].Value)
+		w.Write(caches[imgKey].Value)
 		return
 	}
 
-	houseInit(c)
-
 	exptime := time.Now().Add(5 * time.Minute).UTC().Format(http.TimeFormat)
 
-	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Type", ctype)
 	w.Header().Set("Cache-Control", "max-age=300")
 	w.Header().Set("Expires", exptime)
 
@@ -331,22 +448,18 @@ func Server(w http.ResponseWriter, req *http.Request) {
 		log.Warningf(c, "Error processing batched data: %v.  Might be stale", err)
 	}
 
-	i := drawHouse(c)
-
 	start := time.Now()
-	buf := &bytes.Buffer{}
-	png.Encode(buf, i)
-	log.Debugf(c, "Rebuild house image in %v", time.Since(start))
-	data := buf.Bytes()
-
-	err = memcache.SetMulti(c, []*memcache.Item{
-		&memcache.Item{
-			Key:        houseExpKey,
+	data := render(c, s, opts)
+	log.Debugf(c, "Rebuild %v in %v", name, time.Since(start))
+
+	err = platform.Default.Cache.SetMulti(c, []*platform.CacheItem{
+		&platform.CacheItem{
+			Key:        expKey,
 			Value:      []byte(exptime),
 			Expiration: time.Minute * 5,
 		},
-		&memcache.Item{
-			Key:        houseImgKey,
+		&platform.CacheItem{
+			Key:        imgKey,
 			Value:      data,
 			Expiration: time.Minute * 5,
 		},
@@ -361,7 +474,71 @@ func Server(w http.ResponseWriter, req *http.Request) {
 	w.Write(data)
 }
 
-func drawTemp(i draw.Image, r Reading) {
+// sitePages are what each site serves beneath /house/<site>/.
+var sitePages = map[string]http.HandlerFunc{
+	"":             ServePNG,
+	"house.svg":    ServeSVG,
+	"dashboard":    HandleDashboard,
+	"api/current":  HandleAPICurrent,
+	"api/readings": HandleAPIReadings,
+}
+
+// Server is the main entry point to the house thermometer server.
+// /house/ is a picture of the default site, and every site has its
+// pages beneath /house/<site>/.
+func Server(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/house/")
+	if p == "" {
+		ServePNG(w, req)
+		return
+	}
+
+	c := platform.NewContext(req)
+	i := strings.Index(p, "/")
+	if i < 0 {
+		if isSite(c, p) {
+			http.Redirect(w, req, req.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, req)
+		return
+	}
+
+	h, ok := sitePages[p[i+1:]]
+	if !ok || !isSite(c, p[:i]) {
+		http.NotFound(w, req)
+		return
+	}
+	h(w, req)
+}
+
+// ServePNG serves a site as a PNG image.
+func ServePNG(w http.ResponseWriter, req *http.Request) {
+	serveHouse(w, req, "image/png", houseImgKey, func(c context.Context, s *site, opts renderOpts) []byte {
+		buf := &bytes.Buffer{}
+		png.Encode(buf, drawHouse(c, s, opts))
+		return buf.Bytes()
+	})
+}
+
+// ServeSVG serves a site as an SVG drawing.
+func ServeSVG(w http.ResponseWriter, req *http.Request) {
+	serveHouse(w, req, "image/svg+xml", houseSVGKey, func(c context.Context, s *site, opts renderOpts) []byte {
+		hc := s.config()
+		alldata := getReadings(c, s)
+		floors, bounds, _ := s.layout(opts.floor)
+
+		sr := newSVGRenderer(bounds, hc)
+		for _, f := range floors {
+			sr.beginFloor(f)
+			renderHouse(c, s, sr, alldata, f.name, opts)
+			sr.endFloor()
+		}
+		return sr.Bytes()
+	})
+}
+
+func drawTemp(i draw.Image, hc *HouseConfig, r Reading, units Unit) {
 	x1, y1 := float64(66), float64(65)
 
 	// Translate the angle because we're a little crooked
@@ -396,12 +573,12 @@ func drawTemp(i draw.Image, r Reading) {
 	c.SetSrc(image.Black)
 
 	pt := freetype.Pt(52, 72+int(c.PointToFix32(10)>>8))
-	c.DrawString(fmt.Sprintf("%.2f", r.Reading), pt)
+	c.DrawString(hc.display(hc.BySerial(r.Serial), r.Reading, units), pt)
 
 }
 
 // Warmup initializes all the house bits.
 func Warmup(w http.ResponseWriter, req *http.Request) {
-	houseInit(appengine.NewContext(req))
+	houseInit(platform.NewContext(req))
 	w.WriteHeader(204)
 }
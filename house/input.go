@@ -4,16 +4,14 @@ import (
 	"encoding/json"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
 	"golang.org/x/net/context"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
-	"google.golang.org/appengine/taskqueue"
+	"platform"
+	"platform/log"
 )
 
 const (
@@ -22,14 +20,11 @@ const (
 	maxItems       = 250
 	maxPullTasks   = 1000
 	maxPersistSize = 100
-	persistEnabled = false
 	currentExpiry  = time.Minute * 15
 	sensorExpiry   = time.Hour * 24
 	pConsume       = 0.01 // Probability of consuming after input
 )
 
-var whitelist = map[string]bool{"162.230.117.10": true}
-
 func init() {
 	rand.Seed(int64(time.Now().Nanosecond()))
 }
@@ -39,119 +34,298 @@ func showError(c context.Context, w http.ResponseWriter, e string, code int) {
 	log.Errorf(c, "Error response: %v (%v)", e, code)
 }
 
-func prepareOne(c context.Context, sn, ts, r string) (*taskqueue.Task, error) {
-	f, err := strconv.ParseFloat(r, 64)
+func prepareOne(reading Reading) *platform.Task {
+	data, err := json.Marshal(&reading)
+	must(err)
+
+	return &platform.Task{Payload: data}
+}
+
+func jsonItem(key string, v interface{}, exp time.Duration) *platform.CacheItem {
+	data, err := json.Marshal(v)
+	must(err)
+	return &platform.CacheItem{Key: key, Value: data, Expiration: exp}
+}
+
+func mightConsume() bool {
+	return rand.Float64() < pConsume
+}
+
+// inputResult is the response to an input request.  Every record in
+// the request is listed by index as either accepted or rejected.
+// Rejections marked Retry failed for reasons other than the record
+// itself and may be sent again.
+//
+// Duplicates counts accepted records that were already stored or that
+// repeat an earlier record in the same request.  They aren't queued
+// again.
+type inputResult struct {
+	Accepted   []int         `json:"accepted"`
+	Rejected   []recordError `json:"rejected"`
+	Duplicates int           `json:"duplicates"`
+}
+
+func (res *inputResult) reject(i int, msg string, retry bool) {
+	res.Rejected = append(res.Rejected, recordError{i, msg, retry})
+}
+
+// A duplicate is a record with the same Key as the record at index of,
+// or as one already cached when of is negative.
+type duplicate struct {
+	index, of int
+}
+
+// dropDuplicates removes records that repeat one earlier in the
+// request or one in the sensor's cached readings.
+func dropDuplicates(c context.Context, recs []inputRecord) ([]inputRecord, []duplicate) {
+	sites := allSites(c)
+	keys := []string{}
+	for _, rec := range recs {
+		sn := rec.Reading.Serial
+		keys = append(keys, siteOf(sites, sn).key("r-"+sn))
+	}
+	cached, err := platform.Default.Cache.GetMulti(c, keys)
 	if err != nil {
-		return nil, err
+		log.Warningf(c, "memcache multiget failure: %v", err)
 	}
 
-	t, err := time.Parse(time.RFC3339Nano, ts)
-	if err != nil {
-		return nil, err
+	seen := map[string]int{}
+	for _, it := range cached {
+		var rs Readings
+		if json.Unmarshal(it.Value, &rs) == nil {
+			for _, r := range rs {
+				seen[r.Key()] = -1
+			}
+		}
 	}
 
-	reading := Reading{Reading: f, Serial: sn, Timestamp: t}
+	var dups []duplicate
+	rv := recs[:0]
+	for _, rec := range recs {
+		k := rec.Reading.Key()
+		if of, ok := seen[k]; ok {
+			dups = append(dups, duplicate{rec.Index, of})
+			continue
+		}
+		seen[k] = rec.Index
+		rv = append(rv, rec)
+	}
+	return rv, dups
+}
 
-	data, err := json.Marshal(&reading)
-	must(err)
+// settle gives each duplicate the same outcome as the record it
+// repeats.  Those already stored are accepted.
+func (res *inputResult) settle(dups []duplicate) {
+	accepted := map[int]bool{}
+	for _, i := range res.Accepted {
+		accepted[i] = true
+	}
+	rejected := map[int]recordError{}
+	for _, e := range res.Rejected {
+		rejected[e.Index] = e
+	}
 
-	return &taskqueue.Task{
-		Payload: data,
-		Method:  "PULL",
-	}, nil
+	for _, d := range dups {
+		if e, ok := rejected[d.of]; ok {
+			res.reject(d.index, e.Error, e.Retry)
+			continue
+		}
+		if d.of < 0 || accepted[d.of] {
+			res.Accepted = append(res.Accepted, d.index)
+			res.Duplicates++
+		}
+	}
+
+	sort.Ints(res.Accepted)
+	sort.Sort(byIndex(res.Rejected))
 }
 
-func mightConsume() bool {
-	return rand.Float64() < pConsume
+// queueRecords enqueues a chunk of records, recording which made it.
+// It returns the tasks that were added.
+func queueRecords(c context.Context, recs []inputRecord, res *inputResult) []*platform.Task {
+	tasks := make([]*platform.Task, 0, len(recs))
+	for _, rec := range recs {
+		tasks = append(tasks, prepareOne(rec.Reading))
+	}
+
+	added, err := platform.Default.Queue.AddMulti(c, readingQueue, tasks)
+	me, partial := err.(platform.MultiError)
+	if partial && len(added) != len(tasks) {
+		partial = false
+	}
+	if err != nil {
+		log.Warningf(c, "Error queueing %v readings: %v", len(tasks), err)
+	}
+
+	var rv []*platform.Task
+	for i, rec := range recs {
+		switch {
+		case err == nil || partial && me[i] == nil:
+			res.Accepted = append(res.Accepted, rec.Index)
+			rv = append(rv, added[i])
+		case partial:
+			res.reject(rec.Index, "error queueing: "+me[i].Error(), true)
+		default:
+			res.reject(rec.Index, "error queueing: "+err.Error(), true)
+		}
+	}
+	return rv
+}
+
+// unqueue backs out tasks added by an atomic request that couldn't
+// be completed.  Anything that can't be removed stays accepted.
+func unqueue(c context.Context, tasks []*platform.Task, res *inputResult) {
+	if len(tasks) == 0 {
+		return
+	}
+	err := platform.Default.Queue.DeleteMulti(c, readingQueue, tasks)
+	me, partial := err.(platform.MultiError)
+	if err != nil && !partial {
+		log.Errorf(c, "Error removing %v queued readings: %v", len(tasks), err)
+		return
+	}
+	accepted := res.Accepted[:0]
+	for i, idx := range res.Accepted {
+		if partial && me[i] != nil {
+			log.Errorf(c, "Error removing queued reading %v: %v", idx, me[i])
+			accepted = append(accepted, idx)
+			continue
+		}
+		res.reject(idx, "batch not stored", true)
+	}
+	res.Accepted = accepted
 }
 
-// HandleInput processes thermometer input.
+// HandleInput processes thermometer input from an authenticated
+// Device.  See decodeInput for the accepted formats.
+//
+// By default bad records are rejected individually while the rest are
+// queued.  With atomic=1 in the query either every record is queued
+// or none are.
 func HandleInput(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := platform.NewContext(r)
 
-	if !whitelist[r.RemoteAddr] {
-		showError(c, w, "Invalid address", 403)
+	dev, err := authenticate(c, r)
+	if err == errTooLarge {
+		showError(c, w, "Signed bodies are limited to "+strconv.Itoa(maxSignedBody)+" bytes", 413)
+		return
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="house"`)
+		showError(c, w, "Unauthorized: "+err.Error(), 401)
 		return
 	}
 
-	r.ParseForm()
-
-	sns := r.Form["sn"]
-	tss := r.Form["ts"]
-	rs := r.Form["r"]
+	atomic := false
+	if s := r.URL.Query().Get("atomic"); s != "" {
+		if atomic, err = strconv.ParseBool(s); err != nil {
+			showError(c, w, "Invalid atomic value: "+strconv.Quote(s), 400)
+			return
+		}
+	}
 
-	if len(sns) != len(tss) || len(sns) != len(rs) {
-		showError(c, w, "Incorrect parameters", 400)
+	b, err := decodeInput(r)
+	if err != nil {
+		showError(c, w, "Error decoding input: "+err.Error(), 400)
 		return
 	}
 
-	shouldConsume := false
+	res := inputResult{Accepted: []int{}, Rejected: b.errors}
+	if res.Rejected == nil {
+		res.Rejected = []recordError{}
+	}
 
-	tasks := []*taskqueue.Task{}
-	for i := range sns {
-		t, err := prepareOne(c, sns[i], tss[i], rs[i])
-		if err != nil {
-			showError(c, w, "Error preparing: "+err.Error(), 400)
-			return
+	var recs []inputRecord
+	for _, rec := range b.records {
+		if !dev.Allows(rec.Reading.Serial) {
+			res.reject(rec.Index, dev.Name+" may not report "+rec.Reading.Serial, false)
+			continue
 		}
-		tasks = append(tasks, t)
-		if len(tasks) >= maxTasksPerAdd {
-			_, err := taskqueue.AddMulti(c, tasks, readingQueue)
-			if err != nil {
-				showError(c, w, "Error queueing things: "+err.Error(), 500)
-				return
-			}
-			tasks = nil
+		recs = append(recs, rec)
+	}
+
+	recs, dups := dropDuplicates(c, recs)
+
+	if atomic && len(res.Rejected) > 0 {
+		for _, rec := range recs {
+			res.reject(rec.Index, "batch rejected", true)
 		}
-		shouldConsume = shouldConsume || mightConsume()
+		recs = nil
 	}
 
-	if len(tasks) > 0 {
-		_, err := taskqueue.AddMulti(c, tasks, readingQueue)
-		if err != nil {
-			showError(c, w, "Error queueing things: "+err.Error(), 500)
-			return
+	var added []*platform.Task
+	for len(recs) > 0 {
+		n := len(recs)
+		if n > maxTasksPerAdd {
+			n = maxTasksPerAdd
+		}
+		added = append(added, queueRecords(c, recs[:n], &res)...)
+		recs = recs[n:]
+		if atomic && len(res.Rejected) > 0 {
+			for _, rec := range recs {
+				res.reject(rec.Index, "batch not stored", true)
+			}
+			unqueue(c, added, &res)
+			break
 		}
 	}
 
-	log.Debugf(c, "Enqueued %v items", len(sns))
+	queued := len(res.Accepted)
+	res.settle(dups)
+
+	log.Debugf(c, "Enqueued %v items, rejected %v, %v duplicates",
+		queued, len(res.Rejected), res.Duplicates)
+	shouldConsume := false
+	for i := 0; i < queued; i++ {
+		shouldConsume = shouldConsume || mightConsume()
+	}
 	if shouldConsume {
 		log.Infof(c, "Consuming input.")
-		taskqueue.Add(c, taskqueue.NewPOSTTask("/cron/house/consume/", nil), "")
+		platform.Default.Queue.Post(c, "/cron/house/consume/")
 	}
 
-	w.WriteHeader(202)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status())
+	json.NewEncoder(w).Encode(res)
 }
 
-func maybePersist(c context.Context, keys []*datastore.Key, obs interface{}) (err error) {
-	if persistEnabled {
-		_, err = datastore.PutMulti(c, keys, obs)
+// status is the HTTP status for a response: 202 when everything was
+// accepted, 207 for a partial success, 503 when all rejections may be
+// retried and 400 otherwise.
+func (res inputResult) status() int {
+	switch {
+	case len(res.Rejected) == 0:
+		return 202
+	case len(res.Accepted) > 0:
+		return 207
 	}
-	return
+	for _, e := range res.Rejected {
+		if !e.Retry {
+			return 400
+		}
+	}
+	return 503
 }
 
 func persistReadings(c context.Context, ch <-chan *Reading, ech chan<- error) {
-	keys := []*datastore.Key{}
 	obs := []*Reading{}
 
 	var err error
 
 	for r := range ch {
-		keys = append(keys, datastore.NewKey(c, "Reading", r.Key(), 0, nil))
 		obs = append(obs, r)
 
-		if len(keys) >= maxPersistSize {
-			err = maybePersist(c, keys, obs)
+		if len(obs) >= maxPersistSize {
+			err = history.Put(c, obs)
 			if err != nil {
 				break
 			}
-			keys = nil
 			obs = nil
 		}
 	}
 
-	if err == nil && len(keys) > 0 {
-		err = maybePersist(c, keys, obs)
+	if err == nil && len(obs) > 0 {
+		err = history.Put(c, obs)
 	}
 
 	// Consume anything that might be left over in the error case
@@ -162,7 +336,7 @@ func persistReadings(c context.Context, ch <-chan *Reading, ech chan<- error) {
 }
 
 func processBatch(c context.Context) (int, error) {
-	tasks, err := taskqueue.Lease(c, maxPullTasks, readingQueue, 60)
+	tasks, err := platform.Default.Queue.Lease(c, readingQueue, maxPullTasks, time.Minute)
 	if err != nil {
 		return 0, err
 	}
@@ -173,37 +347,77 @@ func processBatch(c context.Context) (int, error) {
 	m := map[string]Readings{}
 
 	rch := make(chan *Reading, maxPersistSize)
-	ech := make(chan error, 2)
+	ech := make(chan error, 3)
 
 	go persistReadings(c, rch, ech)
 
+	// Each sensor belongs to the site that claims it, and its
+	// readings are cached and stored under that site's keys.
+	sites := allSites(c)
+	siteFor := map[string]*site{}
+
+	// The same reading may have been posted more than once.  Only the
+	// first of each is kept, here and in the cached lists below.
+	seen := map[string]bool{}
+	dups := 0
+
+	leased := make([]Reading, 0, len(tasks))
+	stored := make([]Reading, 0, len(tasks))
 	for _, task := range tasks {
 		r := Reading{}
 		must(json.Unmarshal(task.Payload, &r))
+		if seen[r.Key()] {
+			dups++
+			continue
+		}
+		seen[r.Key()] = true
 		m[r.Serial] = append(m[r.Serial], r)
-		rch <- &r
+		s := siteFor[r.Serial]
+		if s == nil {
+			s = siteOf(sites, r.Serial)
+			siteFor[r.Serial] = s
+		}
+		leased = append(leased, r)
+		st := r
+		st.Serial = s.key(r.Serial)
+		stored = append(stored, st)
+		rch <- &st
 	}
 	close(rch)
 
-	keys := []string{"current"}
+	go func() {
+		ech <- updateRollups(c, stored)
+	}()
+
+	// Each sensor's latest reading goes to the current readings of
+	// its site.
+	currents := map[*site]map[string]float64{}
+	serialOf := map[string]string{}
+	var keys []string
 	for k := range m {
-		keys = append(keys, "r-"+k)
+		s := siteFor[k]
+		if currents[s] == nil {
+			currents[s] = map[string]float64{}
+			keys = append(keys, s.key("current"))
+		}
+		serialOf[s.key("r-"+k)] = k
+		keys = append(keys, s.key("r-"+k))
 	}
 
-	cached, err := memcache.GetMulti(c, keys)
+	cached, err := platform.Default.Cache.GetMulti(c, keys)
 	if err != nil {
 		log.Warningf(c, "memcache multiget failure: %v", err)
 	}
 
-	currentItem := cached["current"]
-	delete(cached, "current")
-	current := map[string]float64{}
-	if currentItem != nil {
-		json.Unmarshal(currentItem.Value, &current)
+	for s, current := range currents {
+		if it := cached[s.key("current")]; it != nil {
+			json.Unmarshal(it.Value, &current)
+		}
+		delete(cached, s.key("current"))
 	}
 
 	for k, cv := range cached {
-		sn := k[2:]
+		sn := serialOf[k]
 		var v Readings
 		err := json.Unmarshal(cv.Value, &v)
 		if err == nil {
@@ -211,34 +425,45 @@ func processBatch(c context.Context) (int, error) {
 		}
 	}
 
-	items := []*memcache.Item{&memcache.Item{
-		Key:        "current",
-		Expiration: currentExpiry,
-		Object:     current,
-	}}
+	var items []*platform.CacheItem
 	for k, v := range m {
-		v.Sort()
+		n := len(v)
+		v = v.Dedupe()
+		dups += n - len(v)
 		if len(v) > maxItems {
 			v = v[:maxItems]
 		}
-		current[k] = v[0].Reading
-		items = append(items, &memcache.Item{
-			Key:        "r-" + k,
-			Expiration: sensorExpiry,
-			Object:     v,
-		})
+		currents[siteFor[k]][k] = v[0].Reading
+		items = append(items, jsonItem(siteFor[k].key("r-"+k), v, sensorExpiry))
+	}
+	for s, current := range currents {
+		items = append(items, jsonItem(s.key("current"), current, currentExpiry))
 	}
 
 	go func() {
-		ech <- memcache.JSON.SetMulti(c, items)
+		ech <- platform.Default.Cache.SetMulti(c, items)
 	}()
 
-	err = consumeErrors(ech, 2)
+	err = consumeErrors(ech, 3)
 	if err != nil {
+		log.Warningf(c, "Error storing batch, leaving tasks for retry: %v", err)
 		return 0, nil
 	}
 
-	must(taskqueue.DeleteMulti(c, tasks, readingQueue))
+	must(platform.Default.Queue.DeleteMulti(c, readingQueue, tasks))
+	if dups > 0 {
+		log.Infof(c, "Dropped %v duplicate readings", dups)
+	}
+
+	bySite := map[*site][]Reading{}
+	for _, r := range leased {
+		bySite[siteFor[r.Serial]] = append(bySite[siteFor[r.Serial]], r)
+	}
+	for s, rs := range bySite {
+		if err := evaluateAlerts(c, s, rs); err != nil {
+			log.Errorf(c, "Error evaluating alerts for %v: %v", s.name, err)
+		}
+	}
 
 	return len(tasks), nil
 }
@@ -267,7 +492,7 @@ func processInput(c context.Context) error {
 
 // ConsumeInput is the entry point for batch processing the input queue.
 func ConsumeInput(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := platform.NewContext(r)
 
 	if err := processInput(c); err != nil {
 		showError(c, w, "Error processing batch: "+err.Error(), 500)
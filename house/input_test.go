@@ -0,0 +1,40 @@
+package house
+
+import "testing"
+
+func TestInputResultStatus(t *testing.T) {
+	tests := []struct {
+		res inputResult
+		exp int
+	}{
+		{inputResult{}, 202},
+		{inputResult{Accepted: []int{0, 1}}, 202},
+		{inputResult{Accepted: []int{0}, Rejected: []recordError{{Index: 1}}}, 207},
+		{inputResult{Rejected: []recordError{{Index: 0, Retry: true}}}, 503},
+		{inputResult{Rejected: []recordError{{Index: 0, Retry: true}, {Index: 1}}}, 400},
+	}
+
+	for _, test := range tests {
+		if got := test.res.status(); got != test.exp {
+			t.Errorf("Expected %v for %+v, got %v", test.exp, test.res, got)
+		}
+	}
+}
+
+func TestSettleDuplicates(t *testing.T) {
+	res := inputResult{
+		Accepted: []int{0, 3},
+		Rejected: []recordError{{Index: 1, Error: "queue", Retry: true}},
+	}
+	res.settle([]duplicate{{4, 1}, {2, 0}, {5, -1}})
+
+	if len(res.Accepted) != 4 || res.Accepted[1] != 2 || res.Accepted[3] != 5 {
+		t.Errorf("Unexpected accepted: %v", res.Accepted)
+	}
+	if len(res.Rejected) != 2 || res.Rejected[1].Index != 4 || !res.Rejected[1].Retry {
+		t.Errorf("Unexpected rejected: %+v", res.Rejected)
+	}
+	if res.Duplicates != 2 {
+		t.Errorf("Expected 2 duplicates, got %v", res.Duplicates)
+	}
+}
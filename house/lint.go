@@ -0,0 +1,69 @@
+package house
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Lint finds things in a configuration that are allowed but probably
+// mistakes: rooms on the same floor that overlap and rooms that are
+// never drawn.  If known serials are given (e.g. every sensor that reports),
+// it also finds serials no room uses and rooms whose serial isn't
+// among them.
+func (hc *HouseConfig) Lint(known []string) []string {
+	var rv []string
+
+	names := make([]string, 0, len(hc.Rooms))
+	for name, r := range hc.Rooms {
+		if r != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for i, a := range names {
+		ra := hc.Rooms[a]
+		for _, b := range names[i+1:] {
+			rb := hc.Rooms[b]
+			if hc.floorOf(ra) == hc.floorOf(rb) && ra.overlaps(rb) {
+				rv = append(rv, fmt.Sprintf("rooms %v and %v overlap at %v", a, b,
+					ra.Rect.rectangle().Intersect(rb.Rect.rectangle())))
+			}
+		}
+	}
+
+	drawn := map[string]bool{}
+	for _, n := range hc.Colorize {
+		drawn[n] = true
+	}
+	for _, n := range names {
+		if !drawn[n] {
+			rv = append(rv, fmt.Sprintf("room %v isn't in colorize, so it's never drawn", n))
+		}
+	}
+
+	if len(known) > 0 {
+		isKnown := map[string]bool{}
+		for _, sn := range known {
+			isKnown[sn] = true
+			if hc.BySerial(sn) == nil {
+				rv = append(rv, fmt.Sprintf("serial %v isn't used by any room", sn))
+			}
+		}
+		for _, n := range names {
+			if sn := hc.serialOf(n); !isKnown[sn] {
+				rv = append(rv, fmt.Sprintf("room %v uses unknown serial %v", n, sn))
+			}
+		}
+	}
+
+	return rv
+}
+
+// serialOf is the serial number of the named room.
+func (hc *HouseConfig) serialOf(name string) string {
+	if sn := hc.Rooms[name].SN; sn != "" {
+		return sn
+	}
+	return name
+}
@@ -0,0 +1,35 @@
+package house
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	hc, err := ParseConfig([]byte(`{
+		"dims": {"w": 100, "h": 100},
+		"rooms": {
+			"a": {"sn": "1", "min": 1, "max": 2, "rect": {"x": 0, "y": 0, "w": 10, "h": 10}},
+			"b": {"sn": "2", "min": 1, "max": 2, "rect": {"x": 5, "y": 5, "w": 10, "h": 10}},
+			"c": {"min": 1, "max": 2, "rect": {"x": 10, "y": 0, "w": 10, "h": 5}}
+		},
+		"colorize": ["a", "b"]}`))
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+
+	if got := hc.NameOf("2"); got != "b" {
+		t.Errorf("Expected serial 2 to be b, got %v", got)
+	}
+
+	exp := []string{
+		"rooms a and b overlap at (5,5)-(10,10)",
+		"room c isn't in colorize, so it's never drawn",
+		"serial 3 isn't used by any room",
+		"room b uses unknown serial 2",
+		"room c uses unknown serial c",
+	}
+	if got := hc.Lint([]string{"1", "3"}); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected\n%q\ngot\n%q", exp, got)
+	}
+}
@@ -0,0 +1,192 @@
+package house
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// A renderer draws the parts of the house picture.
+type renderer interface {
+	drawBox(room *Room)
+	fill(room *Room, reading float64)
+	fillSolid(room *Room, c color.Color)
+	drawLabel(room *Room, lbl string)
+	drawSparklines(room *Room, rs []*Reading)
+	// tooltip describes a room for anyone pointing at it.
+	tooltip(room *Room, text string)
+}
+
+// pngRenderer draws into a raster image.
+type pngRenderer struct {
+	img *image.NRGBA
+	hc  *HouseConfig
+}
+
+func (p *pngRenderer) drawBox(room *Room) {
+	drawBox(p.img, room)
+}
+
+func (p *pngRenderer) fill(room *Room, reading float64) {
+	fill(p.img, p.hc, room, reading)
+}
+
+func (p *pngRenderer) fillSolid(room *Room, c color.Color) {
+	fillSolid(p.img, room, c)
+}
+
+func (p *pngRenderer) drawLabel(room *Room, lbl string) {
+	drawLabel(p.img, room, lbl)
+}
+
+func (p *pngRenderer) drawSparklines(room *Room, rs []*Reading) {
+	drawSparklines(p.img, room, rs)
+}
+
+func (p *pngRenderer) tooltip(room *Room, text string) {}
+
+// svgRenderer builds an SVG document.
+type svgRenderer struct {
+	hc   *HouseConfig
+	head bytes.Buffer
+	defs bytes.Buffer
+	body bytes.Buffer
+	n    int
+}
+
+func newSVGRenderer(bounds image.Rectangle, hc *HouseConfig) *svgRenderer {
+	rv := &svgRenderer{hc: hc}
+	fmt.Fprintf(&rv.head, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" `+
+		`width="%d" height="%d" viewBox="%d %d %d %d">`+"\n",
+		bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy())
+	return rv
+}
+
+// beginFloor starts a group holding one floor, placed where the
+// layout put it, over its plan.
+func (s *svgRenderer) beginFloor(f floorView) {
+	fmt.Fprintf(&s.body, `<g transform="translate(%d,%d)">`+"\n", f.at.X, f.at.Y)
+	if f.name != "" {
+		fmt.Fprintf(&s.body, `<title>%s</title>`+"\n", escapeXML(f.name))
+	}
+	if f.url != "" {
+		b := f.plan.Bounds()
+		fmt.Fprintf(&s.body, `<image xlink:href="%s" x="%d" y="%d" width="%d" height="%d"/>`+"\n",
+			escapeXML(f.url), b.Min.X, b.Min.Y, b.Dx(), b.Dy())
+	}
+}
+
+func (s *svgRenderer) endFloor() {
+	s.body.WriteString("</g>\n")
+}
+
+func escapeXML(s string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+func svgColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3f)", n.R, n.G, n.B, float64(n.A)/255)
+}
+
+func (s *svgRenderer) rect(r Rect, attrs string) {
+	fmt.Fprintf(&s.body, `<rect x="%d" y="%d" width="%d" height="%d" %s/>`+"\n",
+		r.X, r.Y, r.W, r.H, attrs)
+}
+
+// shape draws a room as a rect or polygon.
+func (s *svgRenderer) shape(room *Room, attrs string) {
+	if len(room.Polygon) == 0 {
+		s.rect(room.Rect, attrs)
+		return
+	}
+	s.body.WriteString(`<polygon points="`)
+	for _, p := range room.Polygon {
+		fmt.Fprintf(&s.body, "%d,%d ", p.X, p.Y)
+	}
+	s.body.WriteString(`" ` + attrs + "/>\n")
+}
+
+func (s *svgRenderer) drawBox(room *Room) {
+	if len(room.Polygon) > 0 {
+		s.shape(room, `fill="black" stroke="black" stroke-width="2"`)
+		return
+	}
+	s.rect(Rect{room.Rect.X - 1, room.Rect.Y - 1, room.Rect.W + 2, room.Rect.H + 2},
+		`fill="black"`)
+}
+
+// fill mirrors the PNG gradient: color fades linearly with distance
+// from the thermometer until MaxRelevantDistance.
+func (s *svgRenderer) fill(room *Room, reading float64) {
+	switch {
+	case reading < room.Min:
+		s.fillSolid(room, color.NRGBA{0, 0, 255, 255})
+		return
+	case reading > room.Max:
+		s.fillSolid(room, color.NRGBA{255, 0, 0, 255})
+		return
+	}
+
+	cx, cy := room.center()
+	tx := ifZero(room.Therm.X, cx)
+	ty := ifZero(room.Therm.Y, cy)
+
+	s.n++
+	id := fmt.Sprintf("grad%d", s.n)
+	fmt.Fprintf(&s.defs, `<radialGradient id="%s" gradientUnits="userSpaceOnUse" cx="%d" cy="%d" r="%g">`+
+		`<stop offset="0" stop-color="%s"/><stop offset="1" stop-color="%s"/></radialGradient>`+"\n",
+		id, tx, ty, math.Max(s.hc.MaxRelevantDistance, 1),
+		svgColor(getFillColor(room, reading, 1)), svgColor(getFillColor(room, reading, 0)))
+	s.shape(room, `fill="url(#`+id+`)"`)
+}
+
+func (s *svgRenderer) fillSolid(room *Room, c color.Color) {
+	s.shape(room, `fill="`+svgColor(c)+`"`)
+}
+
+func (s *svgRenderer) drawLabel(room *Room, lbl string) {
+	x, y := labelPos(room, lbl)
+	fmt.Fprintf(&s.body, `<text x="%d" y="%d" font-family="Luxi Mono, monospace" font-size="10">%s</text>`+"\n",
+		x, y+10, escapeXML(lbl))
+}
+
+func (s *svgRenderer) drawSparklines(room *Room, rs []*Reading) {
+	pts := sparkPoints(room, rs)
+	if len(pts) == 0 {
+		return
+	}
+	s.body.WriteString(`<polyline fill="none" stroke="#7f7f7f" stroke-width="1" points="`)
+	for i := len(pts) - 1; i >= 0; i-- {
+		fmt.Fprintf(&s.body, "%d,%d ", pts[i].X, pts[i].Y)
+	}
+	s.body.WriteString(`"/>` + "\n")
+}
+
+func (s *svgRenderer) tooltip(room *Room, text string) {
+	s.body.WriteString("<g>")
+	s.shape(room, `fill="transparent"`)
+	fmt.Fprintf(&s.body, "<title>%s</title></g>\n", escapeXML(text))
+}
+
+// Bytes returns the finished document.
+func (s *svgRenderer) Bytes() []byte {
+	rv := &bytes.Buffer{}
+	rv.Write(s.head.Bytes())
+	if s.defs.Len() > 0 {
+		rv.WriteString("<defs>\n")
+		rv.Write(s.defs.Bytes())
+		rv.WriteString("</defs>\n")
+	}
+	rv.Write(s.body.Bytes())
+	rv.WriteString("</svg>\n")
+	return rv.Bytes()
+}
@@ -0,0 +1,56 @@
+package house
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSVGRenderer(t *testing.T) {
+	room := &Room{SN: "x", Min: 10, Max: 30, Rect: Rect{4, 4, 60, 40}}
+	var rs []*Reading
+	for i := 0; i < 10; i++ {
+		rs = append(rs, &Reading{Reading: float64(20 + i%3), Timestamp: time.Unix(int64(i), 0)})
+	}
+
+	sr := newSVGRenderer(image.Rect(0, 0, 100, 80), &HouseConfig{MaxRelevantDistance: 100})
+	sr.beginFloor(floorView{plan: image.NewNRGBA(image.Rect(0, 0, 100, 80)),
+		url: "/static/house/house.png?a=1&b=2"})
+	sr.drawBox(room)
+	sr.fill(room, 22)
+	sr.fillSolid(room, color.White)
+	sr.drawLabel(room, "22.00 <C>")
+	sr.drawSparklines(room, rs)
+	sr.tooltip(room, "attic & stuff")
+	sr.endFloor()
+
+	data := sr.Bytes()
+	d := xml.NewDecoder(bytes.NewReader(data))
+	elements := map[string]int{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid SVG: %v\n%s", err, data)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			elements[se.Name.Local]++
+		}
+	}
+
+	for _, e := range []string{"svg", "image", "radialGradient", "text", "polyline", "title"} {
+		if elements[e] == 0 {
+			t.Errorf("Expected a %v element in\n%s", e, data)
+		}
+	}
+	if !strings.Contains(string(data), "22.00 &lt;C&gt;") {
+		t.Errorf("Label wasn't escaped in\n%s", data)
+	}
+}
@@ -0,0 +1,258 @@
+package house
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+// A Resolution is the width of a rollup bucket.
+type Resolution struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Resolutions we maintain rollups for, finest first.  Each one is
+// computed from the one before it.
+var resolutions = []Resolution{
+	{"1m", time.Minute},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+var errUnknownResolution = errors.New("unknown resolution")
+
+func resolutionNamed(name string) (Resolution, error) {
+	for _, r := range resolutions {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return Resolution{}, errUnknownResolution
+}
+
+// resolutionFor picks the finest resolution that fits a span into the
+// given number of points.
+func resolutionFor(span time.Duration, points int) Resolution {
+	for _, r := range resolutions {
+		if span/r.Duration <= time.Duration(points) {
+			return r
+		}
+	}
+	return resolutions[len(resolutions)-1]
+}
+
+// A Rollup summarizes the readings of one serial number over one
+// bucket of time.
+type Rollup struct {
+	Serial     string
+	Resolution string
+	Start      time.Time
+	Min        float64   `datastore:",noindex"`
+	Max        float64   `datastore:",noindex"`
+	Sum        float64   `datastore:",noindex"`
+	Mean       float64   `datastore:",noindex"`
+	Count      int       `datastore:",noindex"`
+	Last       float64   `datastore:",noindex"`
+	LastTS     time.Time `datastore:",noindex"`
+
+	// Seen holds the unix timestamps of readings folded into a
+	// finest resolution bucket so the same reading is never
+	// counted twice.
+	Seen []int64 `datastore:",noindex" json:"-"`
+}
+
+func newRollup(sn string, res Resolution, t time.Time) *Rollup {
+	return &Rollup{
+		Serial:     sn,
+		Resolution: res.Name,
+		Start:      t.UTC().Truncate(res.Duration),
+	}
+}
+
+// Key is a unique key for this rollup.
+func (r *Rollup) Key() string {
+	return r.Resolution + "_" + r.Start.Format(keyFormat) + "_" + r.Serial
+}
+
+func (r *Rollup) empty() bool {
+	return r.Count == 0
+}
+
+func (r *Rollup) observe(min, max, sum float64, count int, last float64, lastTS time.Time) {
+	if r.empty() {
+		r.Min, r.Max = min, max
+	} else {
+		r.Min = math.Min(r.Min, min)
+		r.Max = math.Max(r.Max, max)
+	}
+	r.Sum += sum
+	r.Count += count
+	r.Mean = r.Sum / float64(r.Count)
+	if !lastTS.Before(r.LastTS) {
+		r.Last, r.LastTS = last, lastTS
+	}
+}
+
+// add folds a reading into this rollup unless it's already in there.
+func (r *Rollup) add(rd Reading) {
+	ts := rd.Timestamp.Unix()
+	for _, s := range r.Seen {
+		if s == ts {
+			return
+		}
+	}
+	r.Seen = append(r.Seen, ts)
+	r.observe(rd.Reading, rd.Reading, rd.Reading, 1, rd.Reading, rd.Timestamp)
+}
+
+// merge folds a finer grained rollup into this one.
+func (r *Rollup) merge(o *Rollup) {
+	if o.empty() {
+		return
+	}
+	r.observe(o.Min, o.Max, o.Sum, o.Count, o.Last, o.LastTS)
+}
+
+// updateRollups folds readings into the finest resolution and then
+// recomputes every coarser bucket they touch from its children, so
+// processing the same readings twice leaves things as they were.
+func updateRollups(c context.Context, rs []Reading) error {
+	if len(rs) == 0 {
+		return nil
+	}
+
+	buckets := map[string]*Rollup{}
+	var todo []*Rollup
+	for _, rd := range rs {
+		ru := newRollup(rd.Serial, resolutions[0], rd.Timestamp)
+		if buckets[ru.Key()] == nil {
+			buckets[ru.Key()] = ru
+			todo = append(todo, ru)
+		}
+	}
+
+	if err := history.LoadRollups(c, todo); err != nil {
+		return err
+	}
+	for _, rd := range rs {
+		buckets[newRollup(rd.Serial, resolutions[0], rd.Timestamp).Key()].add(rd)
+	}
+	if err := history.PutRollups(c, todo); err != nil {
+		return err
+	}
+
+	for i, res := range resolutions[1:] {
+		finer := resolutions[i]
+		parents := map[string]*Rollup{}
+		var next []*Rollup
+		for _, child := range todo {
+			p := newRollup(child.Serial, res, child.Start)
+			if parents[p.Key()] == nil {
+				parents[p.Key()] = p
+				next = append(next, p)
+			}
+		}
+
+		// Children are loaded by key rather than queried so the
+		// ones just written are seen even where queries are
+		// eventually consistent.
+		var children []*Rollup
+		for _, p := range next {
+			for t := p.Start; t.Before(p.Start.Add(res.Duration)); t = t.Add(finer.Duration) {
+				children = append(children, newRollup(p.Serial, finer, t))
+			}
+		}
+		if err := history.LoadRollups(c, children); err != nil {
+			return err
+		}
+		for _, child := range children {
+			parents[newRollup(child.Serial, res, child.Start).Key()].merge(child)
+		}
+
+		if err := history.PutRollups(c, next); err != nil {
+			return err
+		}
+		todo = next
+	}
+
+	return nil
+}
+
+// sparkSeries returns one point per bucket covering the given span for
+// a room at whatever resolution best fits its sparkline.
+func sparkSeries(c context.Context, s *site, room *Room, span time.Duration) ([]*Reading, error) {
+	res := resolutionFor(span, room.SparkWidth())
+	now := time.Now()
+	rus, err := history.Rollups(c, s.key(room.SN), res.Name, now.Add(-span), now, room.SparkWidth())
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]*Reading, 0, len(rus))
+	for _, ru := range rus {
+		rv = append(rv, &Reading{Serial: room.SN, Reading: ru.Mean, Timestamp: ru.Start})
+	}
+	return rv, nil
+}
+
+// HandleRollups serves rollups for a serial number.
+//
+// Parameters are sn, res (1m, 1h or 1d), from, to, limit and units as
+// for HandleHistory.
+func HandleRollups(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	s, err := requestSite(c, r)
+	if err != nil {
+		showError(c, w, err.Error(), 404)
+		return
+	}
+	hc := s.config()
+
+	sn := r.FormValue("sn")
+	if sn == "" {
+		showError(c, w, "Missing sn", 400)
+		return
+	}
+
+	resName := r.FormValue("res")
+	if resName == "" {
+		resName = "1h"
+	}
+	res, err := resolutionNamed(resName)
+	if err != nil {
+		showError(c, w, "Invalid res: "+resName, 400)
+		return
+	}
+
+	from, to, limit, err := parseRange(r)
+	if err != nil {
+		showError(c, w, "Invalid range: "+err.Error(), 400)
+		return
+	}
+
+	rus, err := history.Rollups(c, s.key(sn), res.Name, from, to, limit)
+	if err != nil {
+		showError(c, w, "Error fetching rollups: "+err.Error(), 500)
+		return
+	}
+	if rus == nil {
+		rus = []*Rollup{}
+	}
+	u := hc.UnitOf(hc.BySerial(sn))
+	units := ParseUnit(r.FormValue("units"))
+	for _, ru := range rus {
+		ru.Serial = sn
+		ru.convert(u, units)
+	}
+	log.Debugf(c, "Found %v %v rollups for %v", len(rus), res.Name, sn)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rus)
+}
@@ -0,0 +1,115 @@
+package house
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRollupsIdempotent(t *testing.T) {
+	defer SetHistoryStore(history)
+	SetHistoryStore(NewFileHistory(t.TempDir()))
+
+	base := time.Date(2016, 1, 15, 22, 59, 0, 0, time.UTC)
+	var rs []Reading
+	for i := 0; i < 4; i++ {
+		rs = append(rs, Reading{
+			Serial:    "attic",
+			Reading:   float64(10 + i),
+			Timestamp: base.Add(time.Duration(i*30) * time.Second),
+		})
+	}
+
+	// The same batch leased twice, then part of it again.
+	for _, batch := range [][]Reading{rs, rs, rs[1:3]} {
+		if err := updateRollups(nil, batch); err != nil {
+			t.Fatalf("Error updating rollups: %v", err)
+		}
+	}
+
+	tests := []struct {
+		res                  string
+		count                int
+		min, max, mean, last float64
+	}{
+		{"1h", 2, 10, 11, 10.5, 11},
+		{"1d", 4, 10, 13, 11.5, 13},
+	}
+
+	for _, test := range tests {
+		rus, err := history.Rollups(nil, "attic", test.res, base.Truncate(24*time.Hour),
+			base.Add(time.Hour), 100)
+		if err != nil {
+			t.Fatalf("Error fetching %v rollups: %v", test.res, err)
+		}
+		if len(rus) == 0 {
+			t.Errorf("No %v rollups found", test.res)
+			continue
+		}
+		ru := rus[len(rus)-1]
+		if ru.Count != test.count || ru.Min != test.min || ru.Max != test.max ||
+			ru.Mean != test.mean || ru.Last != test.last {
+			t.Errorf("Expected %v rollup %+v, got %+v", test.res, test, ru)
+		}
+	}
+
+	mins, err := history.Rollups(nil, "attic", "1m", base, base.Add(time.Hour), 100)
+	if err != nil {
+		t.Fatalf("Error fetching minute rollups: %v", err)
+	}
+	if len(mins) != 2 || mins[0].Count != 2 || mins[1].Count != 2 {
+		t.Errorf("Expected two minute rollups of two, got %+v", mins)
+	}
+}
+
+func TestResolutionFor(t *testing.T) {
+	tests := []struct {
+		span   time.Duration
+		points int
+		exp    string
+	}{
+		{time.Hour, 100, "1m"},
+		{24 * time.Hour, 100, "1h"},
+		{24 * time.Hour, 10, "1d"},
+		{365 * 24 * time.Hour, 10, "1d"},
+	}
+
+	for _, test := range tests {
+		if got := resolutionFor(test.span, test.points); got.Name != test.exp {
+			t.Errorf("Expected %v for %v in %v points, got %v",
+				test.exp, test.span, test.points, got.Name)
+		}
+	}
+}
+
+// laggingHistory's queries never see anything, like an eventually
+// consistent datastore right after a write.
+type laggingHistory struct {
+	HistoryStore
+}
+
+func (laggingHistory) Rollups(c context.Context, sn, res string, from, to time.Time, limit int) ([]*Rollup, error) {
+	return nil, nil
+}
+
+func TestRollupsWithoutQueries(t *testing.T) {
+	defer SetHistoryStore(history)
+	fh := NewFileHistory(t.TempDir())
+	SetHistoryStore(laggingHistory{fh})
+
+	base := time.Date(2016, 1, 15, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		rd := Reading{Serial: "attic", Reading: float64(i), Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		if err := updateRollups(nil, []Reading{rd}); err != nil {
+			t.Fatalf("Error updating rollups: %v", err)
+		}
+	}
+
+	for _, res := range []string{"1h", "1d"} {
+		rus, err := fh.Rollups(nil, "attic", res, base.Truncate(24*time.Hour), base.Add(time.Hour), 10)
+		if err != nil || len(rus) != 1 || rus[0].Count != 3 || rus[0].Max != 2 {
+			t.Errorf("Expected a %v rollup of all three readings, got %+v, %v", res, rus, err)
+		}
+	}
+}
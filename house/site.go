@@ -0,0 +1,372 @@
+package house
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+	"platform/log"
+)
+
+// DefaultSite is the site served at /house/.  Readings from sensors
+// no other site claims go to it as well.
+const DefaultSite = "house"
+
+// reservedSiteNames are pages under /house/ that aren't sites.
+var reservedSiteNames = map[string]bool{
+	"api":       true,
+	"dashboard": true,
+	"history":   true,
+	"input":     true,
+	"rollups":   true,
+}
+
+func validSiteName(name string) error {
+	if name == "" || reservedSiteNames[name] {
+		return fmt.Errorf("invalid site name %q", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("invalid site name %q", name)
+		}
+	}
+	return nil
+}
+
+// A site is one property with its own floor plan, configuration and
+// current readings.  Sites are served at /house/<name>/, except for
+// DefaultSite which is at /house/.
+type site struct {
+	name string
+
+	state atomic.Value // *siteState
+
+	mu      sync.Mutex
+	version int // of the config in use, -1 until one is loaded
+	checked time.Time
+}
+
+// siteState is what a site draws with.  It's replaced whole whenever
+// the active config changes.
+type siteState struct {
+	conf *HouseConfig
+	// The plan image of each of conf.floors(), and where it came from.
+	plans    []image.Image
+	planURLs []string
+}
+
+// floorGap is the space between floors drawn side by side.
+const floorGap = 10
+
+// A floorView is a floor placed in a drawing of a site.
+type floorView struct {
+	name string
+	plan image.Image
+	url  string
+	// at is where the floor's origin is in the drawing.
+	at image.Point
+}
+
+var (
+	sitesMu      sync.Mutex
+	sites        = map[string]*site{}
+	siteNames    []string
+	sitesChecked time.Time
+)
+
+func getSite(name string) *site {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	s := sites[name]
+	if s == nil {
+		s = &site{name: name, version: -1}
+		sites[name] = s
+	}
+	return s
+}
+
+func defaultSite() *site {
+	return getSite(DefaultSite)
+}
+
+// knownSites lists every site: the default one, any with a stored
+// config and any named in the comma separated HOUSE_SITES.
+func knownSites(c context.Context) []string {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+
+	if siteNames != nil && time.Since(sitesChecked) < configCheckEvery {
+		return siteNames
+	}
+
+	names, err := platform.Default.Blobs.Names(c, configActiveKind)
+	if err != nil {
+		log.Warningf(c, "Error listing house sites: %v", err)
+		if siteNames != nil {
+			return siteNames
+		}
+	}
+	names = append(names, DefaultSite)
+	names = append(names, strings.Split(os.Getenv("HOUSE_SITES"), ",")...)
+
+	seen := map[string]bool{}
+	var rv []string
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if seen[n] || validSiteName(n) != nil {
+			continue
+		}
+		seen[n] = true
+		rv = append(rv, n)
+	}
+	sort.Strings(rv)
+
+	siteNames, sitesChecked = rv, time.Now()
+	return rv
+}
+
+// forgetSiteNames makes the next knownSites look again.
+func forgetSiteNames() {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	sitesChecked = time.Time{}
+}
+
+func isSite(c context.Context, name string) bool {
+	for _, n := range knownSites(c) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allSites returns every known site, refreshed.
+func allSites(c context.Context) []*site {
+	var rv []*site
+	for _, n := range knownSites(c) {
+		s := getSite(n)
+		if err := s.refresh(c); err != nil {
+			log.Errorf(c, "Error loading site %v: %v", n, err)
+		}
+		rv = append(rv, s)
+	}
+	return rv
+}
+
+// siteOf finds the site whose config claims a serial number, or the
+// default site when none does.
+func siteOf(sites []*site, sn string) *site {
+	for _, s := range sites {
+		if s.config().BySerial(sn) != nil {
+			return s
+		}
+	}
+	return defaultSite()
+}
+
+// requestSite finds the site a request is for, either from a path
+// beneath /house/<site>/ or a site parameter.  It's loaded and ready
+// to draw.
+func requestSite(c context.Context, r *http.Request) (*site, error) {
+	houseInit(c)
+
+	name := r.FormValue("site")
+	if p := strings.TrimPrefix(r.URL.Path, "/house/"); p != r.URL.Path {
+		if i := strings.Index(p, "/"); i > 0 && isSite(c, p[:i]) {
+			name = p[:i]
+		}
+	}
+	if name == "" {
+		name = DefaultSite
+	}
+	if !isSite(c, name) {
+		return nil, fmt.Errorf("no such site: %v", name)
+	}
+
+	s := getSite(name)
+	return s, s.refresh(c)
+}
+
+// config returns the site's configuration in use.
+func (s *site) config() *HouseConfig {
+	if st, _ := s.state.Load().(*siteState); st != nil {
+		return st.conf
+	}
+	return &HouseConfig{}
+}
+
+// layout places the site's floors side by side for drawing, or picks
+// out the one named.  It returns the floors and the bounds of the
+// whole drawing.
+func (s *site) layout(floor string) ([]floorView, image.Rectangle, error) {
+	st, _ := s.state.Load().(*siteState)
+	if st == nil {
+		return nil, image.Rectangle{}, fmt.Errorf("site %v isn't loaded", s.name)
+	}
+
+	var rv []floorView
+	x, h := 0, 0
+	for i, f := range st.conf.floors() {
+		if floor != "" && f.Name != floor {
+			continue
+		}
+		b := st.plans[i].Bounds()
+		rv = append(rv, floorView{f.Name, st.plans[i], st.planURLs[i], image.Pt(x-b.Min.X, -b.Min.Y)})
+		x += b.Dx() + floorGap
+		if b.Dy() > h {
+			h = b.Dy()
+		}
+	}
+	if len(rv) == 0 {
+		return nil, image.Rectangle{}, fmt.Errorf("no such floor: %v", floor)
+	}
+	return rv, image.Rect(0, 0, x-floorGap, h), nil
+}
+
+// planURL is where a floor's plan image comes from.
+func (s *site) planURL(f Floor) string {
+	switch {
+	case f.Image != "":
+		return f.Image
+	case f.Name != "":
+		return s.static(f.Name + ".png")
+	}
+	return s.static("house.png")
+}
+
+// static is the path to one of the site's shipped files.
+func (s *site) static(file string) string {
+	if s.name == DefaultSite {
+		return "/static/house/" + file
+	}
+	return "/static/house/" + s.name + "/" + file
+}
+
+// path is where the site is served.
+func (s *site) path() string {
+	if s.name == DefaultSite {
+		return "/house/"
+	}
+	return "/house/" + s.name + "/"
+}
+
+// key namespaces a cache or storage key to the site.  The default
+// site's keys are the ones used before there were sites.
+func (s *site) key(k string) string {
+	if s.name == DefaultSite {
+		return k
+	}
+	return s.name + "/" + k
+}
+
+func (s *site) keys(ks []string) []string {
+	rv := make([]string, len(ks))
+	for i, k := range ks {
+		rv[i] = s.key(k)
+	}
+	return rv
+}
+
+func fetchImage(c context.Context, u string) (image.Image, error) {
+	if strings.HasPrefix(u, "/") {
+		u = "http://" + platform.Hostname(c) + u
+	}
+	res, err := platform.HTTPClient(c).Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("http error on %v: %v", u, res.Status)
+	}
+	img, _, err := image.Decode(res.Body)
+	return img, err
+}
+
+// refresh loads the site's active configuration and floor plan if
+// they've changed since they were last checked.  On any trouble
+// whatever was in use stays in use.  An error is returned only if
+// there's nothing to use at all.
+func (s *site) refresh(c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded := s.version >= 0
+	if loaded && time.Since(s.checked) < configCheckEvery {
+		return nil
+	}
+	s.checked = time.Now()
+
+	v, err := s.activeVersion(c)
+	if err != nil {
+		log.Warningf(c, "Error checking %v config version: %v", s.name, err)
+		if loaded {
+			return nil
+		}
+	}
+	if loaded && v == s.version {
+		return nil
+	}
+
+	st, err := s.load(c, v, loaded)
+	if err != nil {
+		log.Errorf(c, "Error loading %v config version %v: %v", s.name, v, err)
+		if loaded {
+			return nil
+		}
+		return err
+	}
+
+	s.state.Store(st)
+	s.version = v
+	log.Infof(c, "Using %v config version %v", s.name, v)
+	return nil
+}
+
+// load reads a config version and its floor plans.  A config that
+// fails validation is only used when there's nothing else.
+func (s *site) load(c context.Context, v int, loaded bool) (*siteState, error) {
+	data, err := s.configData(c, v)
+	if err != nil {
+		return nil, err
+	}
+	hc, err := ParseConfig(data)
+	if err != nil {
+		if loaded || hc == nil {
+			return nil, err
+		}
+		log.Errorf(c, "Using invalid %v config version %v: %v", s.name, v, err)
+	}
+
+	have := map[string]image.Image{}
+	if old, _ := s.state.Load().(*siteState); old != nil {
+		for i, u := range old.planURLs {
+			have[u] = old.plans[i]
+		}
+	}
+
+	st := &siteState{conf: hc}
+	for _, f := range hc.floors() {
+		u := s.planURL(f)
+		plan, ok := have[u]
+		if !ok {
+			if plan, err = fetchImage(c, u); err != nil {
+				return nil, err
+			}
+			have[u] = plan
+		}
+		st.plans = append(st.plans, plan)
+		st.planURLs = append(st.planURLs, u)
+	}
+	return st, nil
+}
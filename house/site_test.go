@@ -0,0 +1,155 @@
+package house
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"platform"
+)
+
+func TestValidSiteName(t *testing.T) {
+	tests := map[string]bool{
+		"house":     true,
+		"oroville":  true,
+		"sj-2":      true,
+		"":          false,
+		"api":       false,
+		"Oroville":  false,
+		"a/b":       false,
+		"house.svg": false,
+	}
+	for name, exp := range tests {
+		if got := validSiteName(name) == nil; got != exp {
+			t.Errorf("validSiteName(%q) = %v, want %v", name, got, exp)
+		}
+	}
+}
+
+func TestSiteRouting(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	c := context.Background()
+	cabin := getSite("cabin")
+	_, err := cabin.storeConfig(c, []byte(`{"dims": {"w": 100, "h": 100},
+		"image": "/static/house/house.png",
+		"rooms": {"kitchen": {"sn": "CABIN1", "min": 1, "max": 30, "rect": {"w": 10, "h": 10}}},
+		"colorize": ["kitchen"]}`), "")
+	if err != nil {
+		t.Fatalf("Error storing cabin config: %v", err)
+	}
+
+	h.post("temp,sn=CABIN1 value=12 1451703600000000000\n" +
+		"temp,sn=10E8C214000000E4 value=15 1451703600000000000\n")
+	h.consume()
+
+	if got := getReadings(c, cabin); len(got) != 1 || got["CABIN1"] == nil {
+		t.Errorf("Expected only CABIN1 at the cabin, got %v", got)
+	}
+	if got := getReadings(c, defaultSite()); len(got) != 1 || got["10E8C214000000E4"] == nil {
+		t.Errorf("Expected only the garage at the house, got %v", got)
+	}
+
+	// The cabin's readings are kept under its own keys.
+	var cached Readings
+	if err := platform.GetJSON(c, "r-CABIN1", &cached); err != platform.ErrCacheMiss {
+		t.Errorf("Expected no unprefixed cache entry, got %v, %v", cached, err)
+	}
+	if err := platform.GetJSON(c, cabin.key("r-CABIN1"), &cached); err != nil || len(cached) != 1 {
+		t.Errorf("Expected the cabin's cache entry, got %v, %v", cached, err)
+	}
+	from, to := time.Unix(1451700000, 0), time.Unix(1451710000, 0)
+	if got, err := history.Range(c, "CABIN1", from, to, 10); err != nil || len(got) != 0 {
+		t.Errorf("Expected no unprefixed history, got %v, %v", got, err)
+	}
+	if got, err := history.Range(c, cabin.key("CABIN1"), from, to, 10); err != nil || len(got) != 1 {
+		t.Errorf("Expected the cabin's history, got %v, %v", got, err)
+	}
+	res, body := h.do("GET", "/house/cabin/api/readings?from=2016-01-02T00:00:00Z&to=2016-01-03T00:00:00Z", "", "")
+	if res.StatusCode != 200 || !bytes.Contains(body, []byte(`"serial":"CABIN1"`)) {
+		t.Errorf("Expected the cabin's history from the API, got %v\n%s", res.Status, body)
+	}
+
+	res, body = h.do("GET", "/house/cabin/api/current", "", "")
+	var rs []apiReading
+	if res.StatusCode != 200 || json.Unmarshal(body, &rs) != nil {
+		t.Fatalf("Error getting cabin readings: %v\n%s", res.Status, body)
+	}
+	if len(rs) != 1 || rs[0].Name != "kitchen" || rs[0].Reading != 12 {
+		t.Errorf("Unexpected cabin readings: %+v", rs)
+	}
+
+	_, plan, _ := defaultSite().layout("")
+	if img := h.getPNG("/house/cabin/"); img.Bounds() != plan {
+		t.Errorf("Expected the cabin drawn on its plan, got %v", img.Bounds())
+	}
+
+	for path, code := range map[string]int{
+		"/house/nowhere/":           404,
+		"/house/cabin/nothing":      404,
+		"/house/api/current?site=x": 404,
+	} {
+		if res, _ := h.do("GET", path, "", ""); res.StatusCode != code {
+			t.Errorf("Expected %v for %v, got %v", code, path, res.Status)
+		}
+	}
+}
+
+func TestFloors(t *testing.T) {
+	h := newHarness(t)
+	defer h.Close()
+
+	c := context.Background()
+	_, err := getSite("tall").storeConfig(c, []byte(`{"dims": {"w": 272, "h": 193},
+		"floors": [{"name": "down", "image": "/static/house/house.png"},
+		           {"name": "up", "image": "/static/house/house.png"}],
+		"rooms": {
+			"hall": {"sn": "T1", "min": 1, "max": 30,
+			         "polygon": [{"x": 10, "y": 10}, {"x": 110, "y": 10}, {"x": 110, "y": 40},
+			                     {"x": 40, "y": 40}, {"x": 40, "y": 110}, {"x": 10, "y": 110}]},
+			"attic": {"sn": "T2", "floor": "up", "min": 1, "max": 30, "rect": {"x": 10, "y": 10, "w": 50, "h": 50}}
+		},
+		"colorize": ["hall", "attic"]}`), "")
+	if err != nil {
+		t.Fatalf("Error storing config: %v", err)
+	}
+
+	// Too cold, so the rooms are solid blue.
+	h.post("temp,sn=T1 value=0 1451703600000000000\n" +
+		"temp,sn=T2 value=0 1451703600000000000\n")
+	h.consume()
+
+	both := h.getPNG("/house/tall/")
+	if got, exp := both.Bounds().Dx(), 2*272+floorGap; got != exp {
+		t.Errorf("Expected floors side by side %v wide, got %v", exp, got)
+	}
+	if got := h.getPNG("/house/tall/?floor=up").Bounds().Dx(); got != 272 {
+		t.Errorf("Expected one floor 272 wide, got %v", got)
+	}
+	if res, _ := h.do("GET", "/house/tall/?floor=cellar", "", ""); res.StatusCode != 404 {
+		t.Errorf("Expected 404 for a missing floor, got %v", res.Status)
+	}
+
+	blue := func(x, y int) bool {
+		r, g, b, _ := both.At(x, y).RGBA()
+		return r == 0 && g == 0 && b == 0xffff
+	}
+	for _, p := range []struct {
+		x, y int
+		in   bool
+	}{
+		{20, 100, true},                   // hall, down the side
+		{100, 20, true},                   // hall, along the top
+		{100, 100, false},                 // the notch in the L
+		{272 + floorGap + 30, 50, true},   // attic, upstairs
+		{272 + floorGap + 30, 100, false}, // upstairs, below the attic
+	} {
+		if got := blue(p.x, p.y); got != p.in {
+			t.Errorf("Expected %v,%v filled = %v", p.x, p.y, p.in)
+		}
+	}
+}
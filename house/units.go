@@ -0,0 +1,97 @@
+package house
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Unit names what a reading measures.  Anything other than the
+// constants below is treated as an opaque label.
+type Unit string
+
+// Units we know something about.
+const (
+	Celsius          Unit = "C"
+	Fahrenheit       Unit = "F"
+	RelativeHumidity Unit = "%RH"
+)
+
+// ParseUnit understands the usual ways of asking for a unit.  An empty
+// string means no conversion.
+func ParseUnit(s string) Unit {
+	switch strings.ToLower(s) {
+	case "":
+		return ""
+	case "c", "celsius", "degc":
+		return Celsius
+	case "f", "fahrenheit", "degf":
+		return Fahrenheit
+	case "%rh", "rh", "humidity":
+		return RelativeHumidity
+	}
+	return Unit(s)
+}
+
+func (u Unit) temperature() bool {
+	return u == Celsius || u == Fahrenheit
+}
+
+// Convert a value in this unit to another.  Only temperatures convert;
+// asking for anything else (or nothing) leaves the value alone and
+// returns the unit it's actually in.
+func (u Unit) Convert(v float64, to Unit) (float64, Unit) {
+	if to == "" || to == u || !u.temperature() || !to.temperature() {
+		return v, u
+	}
+	if to == Fahrenheit {
+		return v*9/5 + 32, to
+	}
+	return (v - 32) * 5 / 9, to
+}
+
+// Symbol is how the unit looks next to a number.
+func (u Unit) Symbol() string {
+	switch u {
+	case "":
+		return ""
+	case Celsius:
+		return "°C"
+	case Fahrenheit:
+		return "°F"
+	case RelativeHumidity:
+		return "%"
+	}
+	return " " + string(u)
+}
+
+// Format a value in this unit for display.
+func (u Unit) Format(v float64) string {
+	return fmt.Sprintf("%.2f", v) + u.Symbol()
+}
+
+// convert changes a rollup's values from one unit to another.
+func (r *Rollup) convert(from, to Unit) {
+	if _, u := from.Convert(0, to); u == from {
+		return
+	}
+	for _, v := range []*float64{&r.Min, &r.Max, &r.Mean, &r.Last} {
+		*v, _ = from.Convert(*v, to)
+	}
+	// Conversions have an offset, so the sum follows the mean.
+	r.Sum = r.Mean * float64(r.Count)
+}
+
+// UnitOf returns the unit readings for a room are recorded in.
+func (hc *HouseConfig) UnitOf(room *Room) Unit {
+	if room != nil && room.Unit != "" {
+		return room.Unit
+	}
+	return hc.Unit
+}
+
+// display converts a room's reading into the requested unit and
+// formats it.
+func (hc *HouseConfig) display(room *Room, v float64, to Unit) string {
+	v, u := hc.UnitOf(room).Convert(v, to)
+	return u.Format(v)
+}
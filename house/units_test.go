@@ -0,0 +1,67 @@
+package house
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestUnitConvert(t *testing.T) {
+	tests := []struct {
+		from  Unit
+		v     float64
+		to    Unit
+		exp   float64
+		expu  Unit
+		label string
+	}{
+		{Celsius, 100, Fahrenheit, 212, Fahrenheit, "212.00°F"},
+		{Fahrenheit, 32, Celsius, 0, Celsius, "0.00°C"},
+		{Celsius, 21.5, "", 21.5, Celsius, "21.50°C"},
+		{Celsius, 21.5, Celsius, 21.5, Celsius, "21.50°C"},
+		{RelativeHumidity, 45, Fahrenheit, 45, RelativeHumidity, "45.00%"},
+		{"", 3, Fahrenheit, 3, "", "3.00"},
+		{"lux", 300, Celsius, 300, "lux", "300.00 lux"},
+	}
+
+	for _, test := range tests {
+		got, u := test.from.Convert(test.v, test.to)
+		if math.Abs(got-test.exp) > 0.0001 || u != test.expu {
+			t.Errorf("Expected %v %v -> %v to be %v %v, got %v %v",
+				test.v, test.from, test.to, test.exp, test.expu, got, u)
+		}
+		if l := u.Format(got); l != test.label {
+			t.Errorf("Expected %q, got %q", test.label, l)
+		}
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	tests := map[string]Unit{
+		"":           "",
+		"f":          Fahrenheit,
+		"Fahrenheit": Fahrenheit,
+		"C":          Celsius,
+		"rh":         RelativeHumidity,
+		"lux":        "lux",
+	}
+	for in, exp := range tests {
+		if got := ParseUnit(in); got != exp {
+			t.Errorf("Expected %q for %q, got %q", exp, in, got)
+		}
+	}
+}
+
+func TestRollupConvert(t *testing.T) {
+	r := &Rollup{Min: 0, Max: 100, Sum: 150, Mean: 50, Count: 3, Last: 100}
+	r.convert(Celsius, Fahrenheit)
+	exp := Rollup{Min: 32, Max: 212, Sum: 366, Mean: 122, Count: 3, Last: 212}
+	if !reflect.DeepEqual(*r, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, *r)
+	}
+
+	r.convert(RelativeHumidity, Celsius)
+	if !reflect.DeepEqual(*r, exp) {
+		t.Errorf("Expected humidity left alone, got %+v", *r)
+	}
+}
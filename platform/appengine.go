@@ -0,0 +1,229 @@
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	aelog "google.golang.org/appengine/log"
+	aemail "google.golang.org/appengine/mail"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// AppEngine returns services backed by the App Engine APIs.
+func AppEngine() *Services {
+	return &Services{
+		Context: func(r *http.Request) context.Context {
+			return appengine.NewContext(r)
+		},
+		Hostname: appengine.DefaultVersionHostname,
+		HTTP:     urlfetch.Client,
+		Log:      aeLog,
+
+		Cache: aeCache{},
+		Queue: aeQueue{},
+		Blobs: aeBlobs{},
+		Mail:  aeMailer{},
+	}
+}
+
+func aeLog(c context.Context, l Level, format string, args ...interface{}) {
+	switch l {
+	case Debug:
+		aelog.Debugf(c, format, args...)
+	case Info:
+		aelog.Infof(c, format, args...)
+	case Warning:
+		aelog.Warningf(c, format, args...)
+	case Error:
+		aelog.Errorf(c, format, args...)
+	default:
+		aelog.Criticalf(c, format, args...)
+	}
+}
+
+// aeError translates App Engine errors into their platform
+// equivalents.
+func aeError(err error) error {
+	switch err {
+	case memcache.ErrCacheMiss:
+		return ErrCacheMiss
+	case datastore.ErrNoSuchEntity:
+		return ErrNoSuchEntity
+	case taskqueue.ErrTaskAlreadyAdded:
+		return ErrTaskAlreadyAdded
+	}
+	if me, ok := err.(appengine.MultiError); ok {
+		rv := make(MultiError, len(me))
+		for i, e := range me {
+			rv[i] = aeError(e)
+		}
+		return rv
+	}
+	return err
+}
+
+type aeCache struct{}
+
+func toItem(it *memcache.Item) *CacheItem {
+	return &CacheItem{Key: it.Key, Value: it.Value, Expiration: it.Expiration}
+}
+
+func fromItem(it *CacheItem) *memcache.Item {
+	return &memcache.Item{Key: it.Key, Value: it.Value, Expiration: it.Expiration}
+}
+
+func (aeCache) Get(c context.Context, key string) (*CacheItem, error) {
+	it, err := memcache.Get(c, key)
+	if err != nil {
+		return nil, aeError(err)
+	}
+	return toItem(it), nil
+}
+
+func (aeCache) GetMulti(c context.Context, keys []string) (map[string]*CacheItem, error) {
+	items, err := memcache.GetMulti(c, keys)
+	rv := make(map[string]*CacheItem, len(items))
+	for k, it := range items {
+		rv[k] = toItem(it)
+	}
+	return rv, aeError(err)
+}
+
+func (aeCache) Set(c context.Context, item *CacheItem) error {
+	return aeError(memcache.Set(c, fromItem(item)))
+}
+
+func (aeCache) SetMulti(c context.Context, items []*CacheItem) error {
+	mitems := make([]*memcache.Item, 0, len(items))
+	for _, it := range items {
+		mitems = append(mitems, fromItem(it))
+	}
+	return aeError(memcache.SetMulti(c, mitems))
+}
+
+func (aeCache) Delete(c context.Context, key string) error {
+	return aeError(memcache.Delete(c, key))
+}
+
+type aeQueue struct{}
+
+func (aeQueue) AddMulti(c context.Context, queue string, tasks []*Task) ([]*Task, error) {
+	ts := make([]*taskqueue.Task, 0, len(tasks))
+	for _, t := range tasks {
+		ts = append(ts, &taskqueue.Task{Name: t.Name, Payload: t.Payload, Method: "PULL"})
+	}
+	added, err := taskqueue.AddMulti(c, ts, queue)
+	return addedTasks(len(tasks), added, err)
+}
+
+// addedTasks converts what taskqueue.AddMulti returned.  It returns no
+// tasks with a MultiError when a request couldn't be built, in which
+// case nothing was added, so that's made a total failure.
+func addedTasks(n int, added []*taskqueue.Task, err error) ([]*Task, error) {
+	if _, ok := err.(appengine.MultiError); ok && len(added) != n {
+		return nil, fmt.Errorf("no tasks added: %v", err)
+	}
+	var rv []*Task
+	for _, t := range added {
+		rv = append(rv, &Task{Name: t.Name, Payload: t.Payload})
+	}
+	return rv, aeError(err)
+}
+
+func (aeQueue) Lease(c context.Context, queue string, max int, d time.Duration) ([]*Task, error) {
+	leased, err := taskqueue.Lease(c, max, queue, int(d.Seconds()))
+	var rv []*Task
+	for _, t := range leased {
+		rv = append(rv, &Task{Name: t.Name, Payload: t.Payload})
+	}
+	return rv, aeError(err)
+}
+
+func (aeQueue) DeleteMulti(c context.Context, queue string, tasks []*Task) error {
+	ts := make([]*taskqueue.Task, 0, len(tasks))
+	for _, t := range tasks {
+		ts = append(ts, &taskqueue.Task{Name: t.Name})
+	}
+	return aeError(taskqueue.DeleteMulti(c, ts, queue))
+}
+
+func (aeQueue) Post(c context.Context, path string) error {
+	_, err := taskqueue.Add(c, taskqueue.NewPOSTTask(path, nil), "")
+	return aeError(err)
+}
+
+type aeBlobs struct{}
+
+func aeKeys(c context.Context, kind string, names []string) []*datastore.Key {
+	keys := make([]*datastore.Key, 0, len(names))
+	for _, n := range names {
+		keys = append(keys, datastore.NewKey(c, kind, n, 0, nil))
+	}
+	return keys
+}
+
+func (aeBlobs) Get(c context.Context, kind, name string, dst interface{}) error {
+	return aeError(datastore.Get(c, datastore.NewKey(c, kind, name, 0, nil), dst))
+}
+
+func (aeBlobs) GetMulti(c context.Context, kind string, names []string, dst interface{}) error {
+	return aeError(datastore.GetMulti(c, aeKeys(c, kind, names), dst))
+}
+
+func (aeBlobs) Put(c context.Context, kind, name string, src interface{}) error {
+	_, err := datastore.Put(c, datastore.NewKey(c, kind, name, 0, nil), src)
+	return aeError(err)
+}
+
+func (aeBlobs) PutMulti(c context.Context, kind string, names []string, src interface{}) error {
+	_, err := datastore.PutMulti(c, aeKeys(c, kind, names), src)
+	return aeError(err)
+}
+
+func (aeBlobs) Delete(c context.Context, kind, name string) error {
+	return aeError(datastore.Delete(c, datastore.NewKey(c, kind, name, 0, nil)))
+}
+
+func (aeBlobs) Names(c context.Context, kind string) ([]string, error) {
+	keys, err := datastore.NewQuery(kind).KeysOnly().Order("__key__").GetAll(c, nil)
+	if err != nil {
+		return nil, aeError(err)
+	}
+	rv := make([]string, 0, len(keys))
+	for _, k := range keys {
+		rv = append(rv, k.StringID())
+	}
+	return rv, nil
+}
+
+func (aeBlobs) RunInTransaction(c context.Context, f func(tc context.Context) error) error {
+	return aeError(datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true}))
+}
+
+type aeMailer struct{}
+
+func (aeMailer) Send(c context.Context, msg *Message) error {
+	m := &aemail.Message{
+		Sender:   msg.Sender,
+		To:       msg.To,
+		Subject:  msg.Subject,
+		Body:     msg.Body,
+		HTMLBody: msg.HTMLBody,
+		Headers:  msg.Headers,
+	}
+	for _, a := range msg.Attachments {
+		m.Attachments = append(m.Attachments, aemail.Attachment{
+			Name:      a.Name,
+			Data:      a.Data,
+			ContentID: a.ContentID,
+		})
+	}
+	return aeError(aemail.Send(c, m))
+}
@@ -0,0 +1,32 @@
+package platform
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/taskqueue"
+)
+
+func TestAddedTasks(t *testing.T) {
+	bad := errors.New("bad task")
+	added := []*taskqueue.Task{{Name: "a"}, {}}
+
+	// One of two failed to be added.
+	got, err := addedTasks(2, added, appengine.MultiError{nil, bad})
+	me, ok := err.(MultiError)
+	if !ok || len(got) != 2 || got[0].Name != "a" || me[0] != nil || me[1] != bad {
+		t.Errorf("Expected a partial failure, got %v, %v", got, err)
+	}
+
+	// A request couldn't be built, so nothing was added.
+	got, err = addedTasks(2, nil, appengine.MultiError{nil, bad})
+	if _, ok := err.(MultiError); ok || err == nil || got != nil {
+		t.Errorf("Expected a total failure, got %v, %v", got, err)
+	}
+
+	got, err = addedTasks(2, added, nil)
+	if err != nil || len(got) != 2 {
+		t.Errorf("Expected success, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,125 @@
+package platform
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CronJob is a cron.yaml entry we know how to run.
+type CronJob struct {
+	URL   string
+	Every time.Duration
+}
+
+var cronUnits = map[string]time.Duration{
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"mins":    time.Minute,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+}
+
+// parseSchedule understands the "every N units" form of App Engine
+// schedules.
+func parseSchedule(s string) (time.Duration, error) {
+	f := strings.Fields(s)
+	if len(f) != 3 || f[0] != "every" {
+		return 0, fmt.Errorf("unsupported schedule %q", s)
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval in %q", s)
+	}
+	u, ok := cronUnits[f[2]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q", s)
+	}
+	return time.Duration(n) * u, nil
+}
+
+// ParseCron reads the url and schedule of each entry in a cron.yaml.
+// It only handles the flat layout App Engine documents.
+func ParseCron(src string) ([]CronJob, error) {
+	var rv []CronJob
+	var cur *CronJob
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") {
+			rv = append(rv, CronJob{})
+			cur = &rv[len(rv)-1]
+			line = strings.TrimSpace(line[2:])
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if cur == nil || len(parts) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "url":
+			cur.URL = v
+		case "schedule":
+			d, err := parseSchedule(v)
+			if err != nil {
+				return nil, err
+			}
+			cur.Every = d
+		}
+	}
+	for _, j := range rv {
+		if j.URL == "" || j.Every == 0 {
+			return nil, fmt.Errorf("incomplete cron entry: %+v", j)
+		}
+	}
+	return rv, nil
+}
+
+// LocalRequest runs a request against h as if it came from App Engine
+// itself.
+func LocalRequest(h http.Handler, method, path string, hdr http.Header) {
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		log.Printf("Error building request for %v: %v", path, err)
+		return
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	rec := &statusRecorder{header: http.Header{}}
+	h.ServeHTTP(rec, req)
+	if rec.status >= 300 {
+		log.Printf("%v %v returned %v", method, path, rec.status)
+	}
+}
+
+// Run makes the job's request to h on its schedule forever.
+func (j CronJob) Run(h http.Handler) {
+	for range time.Tick(j.Every) {
+		LocalRequest(h, "GET", j.URL, http.Header{"X-Appengine-Cron": {"true"}})
+	}
+}
+
+// statusRecorder is a ResponseWriter that only keeps the status.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (s *statusRecorder) Header() http.Header { return s.header }
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = 200
+	}
+	return len(b), nil
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+}
@@ -0,0 +1,46 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	jobs, err := ParseCron(`cron:
+- description: update github
+  url: /cron/update/feeds/
+  schedule: every 1 hours
+
+- description: check sensors
+  url: /cron/sensors/check
+  schedule: every 15 minutes
+`)
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	exp := []CronJob{
+		{"/cron/update/feeds/", time.Hour},
+		{"/cron/sensors/check", 15 * time.Minute},
+	}
+	if len(jobs) != len(exp) {
+		t.Fatalf("Expected %v, got %v", exp, jobs)
+	}
+	for i := range exp {
+		if jobs[i] != exp[i] {
+			t.Errorf("Job %v: expected %+v, got %+v", i, exp[i], jobs[i])
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, src := range []string{
+		"cron:\n- url: /x\n  schedule: every day 09:00\n",
+		"cron:\n- url: /x\n  schedule: every 0 minutes\n",
+		"cron:\n- url: /x\n  schedule: every 2 fortnights\n",
+		"cron:\n- description: nothing\n",
+	} {
+		if jobs, err := ParseCron(src); err == nil {
+			t.Errorf("Expected error parsing %q, got %v", src, jobs)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package platform
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DiskStore is a BlobStore keeping each record as a JSON file named
+// dir/kind/name.json.  Transactions run one at a time with the whole
+// store locked, and writes made before a failure stay written.
+type DiskStore struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewDiskStore returns a DiskStore rooted at dir.
+func NewDiskStore(dir string) *DiskStore {
+	return &DiskStore{dir: dir}
+}
+
+// txKey marks a context as being inside one of a DiskStore's
+// transactions, which already holds its lock.
+type txKey struct {
+	d *DiskStore
+}
+
+func (d *DiskStore) lock(c context.Context) func() {
+	if c.Value(txKey{d}) != nil {
+		return func() {}
+	}
+	d.mu.Lock()
+	return d.mu.Unlock
+}
+
+func (d *DiskStore) rlock(c context.Context) func() {
+	if c.Value(txKey{d}) != nil {
+		return func() {}
+	}
+	d.mu.RLock()
+	return d.mu.RUnlock
+}
+
+func (d *DiskStore) path(kind, name string) string {
+	return filepath.Join(d.dir, url.QueryEscape(kind), url.QueryEscape(name)+".json")
+}
+
+func (d *DiskStore) get(kind, name string, dst interface{}) error {
+	data, err := ioutil.ReadFile(d.path(kind, name))
+	if os.IsNotExist(err) {
+		return ErrNoSuchEntity
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func (d *DiskStore) put(kind, name string, src interface{}) error {
+	data, err := json.MarshalIndent(src, "", "  ")
+	if err != nil {
+		return err
+	}
+	fn := d.path(kind, name)
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Get loads a record into dst.
+func (d *DiskStore) Get(c context.Context, kind, name string, dst interface{}) error {
+	defer d.rlock(c)()
+	return d.get(kind, name, dst)
+}
+
+// GetMulti loads records into the slice dst.
+func (d *DiskStore) GetMulti(c context.Context, kind string, names []string, dst interface{}) error {
+	defer d.rlock(c)()
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice || v.Len() != len(names) {
+		return fmt.Errorf("platform: need a slice of %v items", len(names))
+	}
+	me, any := make(MultiError, len(names)), false
+	for i, n := range names {
+		me[i] = d.get(kind, n, v.Index(i).Interface())
+		any = any || me[i] != nil
+	}
+	if any {
+		return me
+	}
+	return nil
+}
+
+// Put stores a record.
+func (d *DiskStore) Put(c context.Context, kind, name string, src interface{}) error {
+	defer d.lock(c)()
+	return d.put(kind, name, src)
+}
+
+// PutMulti stores the records in the slice src.
+func (d *DiskStore) PutMulti(c context.Context, kind string, names []string, src interface{}) error {
+	defer d.lock(c)()
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice || v.Len() != len(names) {
+		return fmt.Errorf("platform: need a slice of %v items", len(names))
+	}
+	for i, n := range names {
+		if err := d.put(kind, n, v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a record.
+func (d *DiskStore) Delete(c context.Context, kind, name string) error {
+	defer d.lock(c)()
+	err := os.Remove(d.path(kind, name))
+	if os.IsNotExist(err) {
+		return ErrNoSuchEntity
+	}
+	return err
+}
+
+// RunInTransaction runs f with the store to itself.
+func (d *DiskStore) RunInTransaction(c context.Context, f func(tc context.Context) error) error {
+	defer d.lock(c)()
+	return f(context.WithValue(c, txKey{d}, true))
+}
+
+// Names lists the records of a kind.
+func (d *DiskStore) Names(c context.Context, kind string) ([]string, error) {
+	defer d.rlock(c)()
+	fis, err := ioutil.ReadDir(filepath.Join(d.dir, url.QueryEscape(kind)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rv []string
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		n, err := url.QueryUnescape(strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		rv = append(rv, n)
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
+// SpoolMailer is a Mailer that writes each message to a file in a
+// directory instead of sending it.
+type SpoolMailer struct {
+	Dir string
+}
+
+// Send writes msg to the spool.
+func (s SpoolMailer) Send(c context.Context, msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.Dir, time.Now().UTC().Format("20060102150405-"))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.Name()+".eml")
+}
+
+func sortedKeys(h mail.Header) []string {
+	var rv []string
+	for k := range h {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Bytes renders the message in RFC 822 form.
+func (m *Message) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", m.Sender)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	for _, k := range sortedKeys(m.Headers) {
+		for _, v := range m.Headers[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, mime.QEncoding.Encode("utf-8", v))
+		}
+	}
+
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		ctype, body string
+	}{{"text/plain", m.Body}, {"text/html", m.HTMLBody}}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {p.ctype + "; charset=utf-8"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(p.body))
+	}
+
+	for _, a := range m.Attachments {
+		h := textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition": {mime.FormatMediaType("attachment",
+				map[string]string{"filename": a.Name})},
+		}
+		if a.ContentID != "" {
+			h.Set("Content-ID", a.ContentID)
+		}
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		enc.Write(a.Data)
+		enc.Close()
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
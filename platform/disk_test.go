@@ -0,0 +1,98 @@
+package platform
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type record struct {
+	Name  string
+	Count int
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := context.Background()
+	d := NewDiskStore(dir)
+
+	if err := d.Get(c, "R", "a", &record{}); err != ErrNoSuchEntity {
+		t.Errorf("Expected no such entity, got %v", err)
+	}
+
+	err = d.PutMulti(c, "R", []string{"b/1", "a"},
+		[]*record{{"b/1", 2}, {"a", 1}})
+	if err != nil {
+		t.Fatalf("Error storing: %v", err)
+	}
+
+	names, err := d.Names(c, "R")
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b/1"}) {
+		t.Errorf("Unexpected names: %v, %v", names, err)
+	}
+
+	got := []*record{{}, {}, {Name: "default"}}
+	err = d.GetMulti(c, "R", []string{"a", "b/1", "c"}, got)
+	me, ok := err.(MultiError)
+	if !ok || me[0] != nil || me[1] != nil || me[2] != ErrNoSuchEntity {
+		t.Fatalf("Expected c to be missing, got %v", err)
+	}
+	if got[0].Count != 1 || got[1].Count != 2 || got[2].Name != "default" {
+		t.Errorf("Unexpected records: %+v %+v %+v", got[0], got[1], got[2])
+	}
+
+	if err := d.Delete(c, "R", "a"); err != nil {
+		t.Errorf("Error deleting: %v", err)
+	}
+	if err := d.Delete(c, "R", "a"); err != ErrNoSuchEntity {
+		t.Errorf("Expected no such entity deleting again, got %v", err)
+	}
+}
+
+func TestSpoolMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = SpoolMailer{dir}.Send(context.Background(), &Message{
+		Sender:      "a@example.com",
+		To:          []string{"b@example.com"},
+		Subject:     "Hello",
+		Body:        "Hi there",
+		Attachments: []Attachment{{Name: "x.txt", Data: []byte("x")}},
+		Headers:     mail.Header{"X-Test": {"yes"}},
+	})
+	if err != nil {
+		t.Fatalf("Error sending: %v", err)
+	}
+
+	fns, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(fns) != 1 {
+		t.Fatalf("Expected one spooled message, got %v", fns)
+	}
+	data, err := ioutil.ReadFile(fns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error reading spooled message: %v", err)
+	}
+	if msg.Header.Get("Subject") != "Hello" || msg.Header.Get("To") != "b@example.com" ||
+		msg.Header.Get("X-Test") != "yes" {
+		t.Errorf("Unexpected headers: %v", msg.Header)
+	}
+}
@@ -0,0 +1,33 @@
+package platform
+
+import (
+	stdlog "log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Local returns services for running outside App Engine.  Records and
+// spooled mail are kept under dir, the cache and queue in memory.  h
+// serves posts to the queue, and host is the name the site is served
+// as.
+func Local(dir, host string, h http.Handler) *Services {
+	client := &http.Client{Timeout: time.Minute}
+	return &Services{
+		Context: func(r *http.Request) context.Context {
+			return context.Background()
+		},
+		Hostname: func(context.Context) string { return host },
+		HTTP:     func(context.Context) *http.Client { return client },
+		Log: func(c context.Context, l Level, format string, args ...interface{}) {
+			stdlog.Printf(l.String()+": "+format, args...)
+		},
+
+		Cache: NewMemoryCache(),
+		Queue: NewMemoryQueue(h),
+		Blobs: NewDiskStore(filepath.Join(dir, "store")),
+		Mail:  SpoolMailer{filepath.Join(dir, "mail")},
+	}
+}
@@ -0,0 +1,34 @@
+// Package log writes log messages through the platform in use.  It
+// mirrors google.golang.org/appengine/log.
+package log
+
+import (
+	"platform"
+
+	"golang.org/x/net/context"
+)
+
+// Debugf formats its arguments and logs them at debug level.
+func Debugf(c context.Context, format string, args ...interface{}) {
+	platform.Default.Log(c, platform.Debug, format, args...)
+}
+
+// Infof is like Debugf, but at info level.
+func Infof(c context.Context, format string, args ...interface{}) {
+	platform.Default.Log(c, platform.Info, format, args...)
+}
+
+// Warningf is like Debugf, but at warning level.
+func Warningf(c context.Context, format string, args ...interface{}) {
+	platform.Default.Log(c, platform.Warning, format, args...)
+}
+
+// Errorf is like Debugf, but at error level.
+func Errorf(c context.Context, format string, args ...interface{}) {
+	platform.Default.Log(c, platform.Error, format, args...)
+}
+
+// Criticalf is like Debugf, but at critical level.
+func Criticalf(c context.Context, format string, args ...interface{}) {
+	platform.Default.Log(c, platform.Critical, format, args...)
+}
@@ -0,0 +1,197 @@
+package platform
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MemoryCache is a Cache held in process memory.
+type MemoryCache struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{items: map[string]memoryItem{}}
+}
+
+func (m *MemoryCache) get(key string, now time.Time) (*CacheItem, bool) {
+	it, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !it.expires.IsZero() && now.After(it.expires) {
+		delete(m.items, key)
+		return nil, false
+	}
+	return &CacheItem{Key: key, Value: it.value}, true
+}
+
+// Get returns the item for key or ErrCacheMiss.
+func (m *MemoryCache) Get(c context.Context, key string) (*CacheItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if it, ok := m.get(key, time.Now()); ok {
+		return it, nil
+	}
+	return nil, ErrCacheMiss
+}
+
+// GetMulti returns the items that are cached.
+func (m *MemoryCache) GetMulti(c context.Context, keys []string) (map[string]*CacheItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	rv := map[string]*CacheItem{}
+	for _, k := range keys {
+		if it, ok := m.get(k, now); ok {
+			rv[k] = it
+		}
+	}
+	return rv, nil
+}
+
+// Set stores an item.
+func (m *MemoryCache) Set(c context.Context, item *CacheItem) error {
+	return m.SetMulti(c, []*CacheItem{item})
+}
+
+// SetMulti stores several items.
+func (m *MemoryCache) SetMulti(c context.Context, items []*CacheItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, it := range items {
+		mi := memoryItem{value: append([]byte(nil), it.Value...)}
+		if it.Expiration > 0 {
+			mi.expires = now.Add(it.Expiration)
+		}
+		m.items[it.Key] = mi
+	}
+	return nil
+}
+
+// Delete removes an item, returning ErrCacheMiss if it wasn't there.
+func (m *MemoryCache) Delete(c context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(key, time.Now()); !ok {
+		return ErrCacheMiss
+	}
+	delete(m.items, key)
+	return nil
+}
+
+// MemoryQueue is a Queue held in process memory.  Posts are served by
+// Handler in the background.
+type MemoryQueue struct {
+	Handler http.Handler
+
+	mu     sync.Mutex
+	seq    int
+	queues map[string][]*memoryTask
+}
+
+type memoryTask struct {
+	Task
+	leased time.Time
+}
+
+// NewMemoryQueue returns an empty MemoryQueue posting to h.
+func NewMemoryQueue(h http.Handler) *MemoryQueue {
+	return &MemoryQueue{Handler: h, queues: map[string][]*memoryTask{}}
+}
+
+func (q *MemoryQueue) find(queue, name string) int {
+	for i, t := range q.queues[queue] {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddMulti adds tasks to the named queue.
+func (q *MemoryQueue) AddMulti(c context.Context, queue string, tasks []*Task) ([]*Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rv := make([]*Task, 0, len(tasks))
+	me, any := make(MultiError, len(tasks)), false
+	for i, t := range tasks {
+		nt := &memoryTask{Task: *t}
+		if nt.Name == "" {
+			q.seq++
+			nt.Name = "task" + strconv.Itoa(q.seq)
+		} else if q.find(queue, nt.Name) >= 0 {
+			me[i], any = ErrTaskAlreadyAdded, true
+		}
+		if me[i] == nil {
+			q.queues[queue] = append(q.queues[queue], nt)
+		}
+		added := nt.Task
+		rv = append(rv, &added)
+	}
+	if any {
+		return rv, me
+	}
+	return rv, nil
+}
+
+// Lease returns up to max tasks not currently leased.
+func (q *MemoryQueue) Lease(c context.Context, queue string, max int, d time.Duration) ([]*Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var rv []*Task
+	for _, t := range q.queues[queue] {
+		if len(rv) >= max {
+			break
+		}
+		if t.leased.After(now) {
+			continue
+		}
+		t.leased = now.Add(d)
+		leased := t.Task
+		rv = append(rv, &leased)
+	}
+	return rv, nil
+}
+
+// DeleteMulti removes tasks from the named queue.
+func (q *MemoryQueue) DeleteMulti(c context.Context, queue string, tasks []*Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, t := range tasks {
+		if i := q.find(queue, t.Name); i >= 0 {
+			ts := q.queues[queue]
+			q.queues[queue] = append(ts[:i], ts[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// Len is the number of tasks in the named queue.
+func (q *MemoryQueue) Len(queue string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[queue])
+}
+
+// Post runs a POST to path against Handler in the background.
+func (q *MemoryQueue) Post(c context.Context, path string) error {
+	go LocalRequest(q.Handler, "POST", path, http.Header{"X-Appengine-Queuename": {"default"}})
+	return nil
+}
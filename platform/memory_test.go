@@ -0,0 +1,79 @@
+package platform
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMemoryCache(t *testing.T) {
+	c := context.Background()
+	m := NewMemoryCache()
+
+	if _, err := m.Get(c, "a"); err != ErrCacheMiss {
+		t.Errorf("Expected a miss, got %v", err)
+	}
+
+	err := m.SetMulti(c, []*CacheItem{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2"), Expiration: time.Nanosecond},
+	})
+	if err != nil {
+		t.Fatalf("Error setting: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	got, err := m.GetMulti(c, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Error getting: %v", err)
+	}
+	if len(got) != 1 || string(got["a"].Value) != "1" {
+		t.Errorf("Expected only a, got %v", got)
+	}
+
+	if err := m.Delete(c, "a"); err != nil {
+		t.Errorf("Error deleting: %v", err)
+	}
+	if err := m.Delete(c, "a"); err != ErrCacheMiss {
+		t.Errorf("Expected a miss deleting again, got %v", err)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	c := context.Background()
+	q := NewMemoryQueue(http.NotFoundHandler())
+
+	added, err := q.AddMulti(c, "q", []*Task{
+		{Payload: []byte("1")},
+		{Name: "named", Payload: []byte("2")},
+		{Name: "named", Payload: []byte("3")},
+	})
+	me, ok := err.(MultiError)
+	if !ok || me[0] != nil || me[1] != nil || me[2] != ErrTaskAlreadyAdded {
+		t.Fatalf("Expected the third task to be a duplicate, got %v", err)
+	}
+	if len(added) != 3 || added[0].Name == "" || q.Len("q") != 2 {
+		t.Fatalf("Unexpected tasks: %v (%v queued)", added, q.Len("q"))
+	}
+
+	leased, err := q.Lease(c, "q", 1, time.Minute)
+	if err != nil || len(leased) != 1 || string(leased[0].Payload) != "1" {
+		t.Fatalf("Unexpected lease: %v, %v", leased, err)
+	}
+	more, err := q.Lease(c, "q", 10, time.Minute)
+	if err != nil || len(more) != 1 || more[0].Name != "named" {
+		t.Fatalf("Expected only the unleased task, got %v, %v", more, err)
+	}
+	if none, _ := q.Lease(c, "q", 10, time.Minute); len(none) != 0 {
+		t.Errorf("Expected everything to be leased, got %v", none)
+	}
+
+	if err := q.DeleteMulti(c, "q", append(leased, more...)); err != nil {
+		t.Errorf("Error deleting: %v", err)
+	}
+	if q.Len("q") != 0 {
+		t.Errorf("Expected an empty queue, have %v", q.Len("q"))
+	}
+}
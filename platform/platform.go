@@ -0,0 +1,194 @@
+// Package platform is the seam between westspy and the services it
+// runs on.  By default everything is backed by App Engine.  Local
+// provides stand-ins so the same handlers can run on a plain net/http
+// server.
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrCacheMiss is returned when a key isn't in the cache.
+	ErrCacheMiss = errors.New("platform: cache miss")
+	// ErrNoSuchEntity is returned when a record isn't in the store.
+	ErrNoSuchEntity = errors.New("platform: no such entity")
+	// ErrTaskAlreadyAdded is returned when a named task already exists.
+	ErrTaskAlreadyAdded = errors.New("platform: task already added")
+)
+
+// MultiError reports the outcome of each item in a batch operation.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var msgs []string
+	for _, e := range m {
+		if e != nil {
+			msgs = append(msgs, e.Error())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// A Level is the severity of a log message.
+type Level int
+
+// Log levels, least severe first.
+const (
+	Debug Level = iota
+	Info
+	Warning
+	Error
+	Critical
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "UNKNOWN"
+	}
+	return levelNames[l]
+}
+
+// A CacheItem is a value in a Cache.
+type CacheItem struct {
+	Key        string
+	Value      []byte
+	Expiration time.Duration
+}
+
+// Cache is a lossy key/value cache in the style of memcache.
+type Cache interface {
+	Get(c context.Context, key string) (*CacheItem, error)
+	// GetMulti returns the items that were found.
+	GetMulti(c context.Context, keys []string) (map[string]*CacheItem, error)
+	Set(c context.Context, item *CacheItem) error
+	SetMulti(c context.Context, items []*CacheItem) error
+	Delete(c context.Context, key string) error
+}
+
+// A Task is an item in a pull Queue.
+type Task struct {
+	Name    string
+	Payload []byte
+}
+
+// Queue holds pull tasks for later batch processing.
+type Queue interface {
+	// AddMulti adds tasks, naming any that are unnamed.  Individual
+	// failures are reported with a MultiError, in which case the
+	// returned tasks are still valid for those that succeeded.
+	AddMulti(c context.Context, queue string, tasks []*Task) ([]*Task, error)
+	// Lease hands out up to max tasks that nobody else holds.
+	Lease(c context.Context, queue string, max int, d time.Duration) ([]*Task, error)
+	DeleteMulti(c context.Context, queue string, tasks []*Task) error
+	// Post asks for a POST to path to be run in the background.
+	Post(c context.Context, path string) error
+}
+
+// BlobStore keeps small records by kind and name.  Values are pointers
+// to structs; multi operations take slices of them.
+type BlobStore interface {
+	Get(c context.Context, kind, name string, dst interface{}) error
+	// GetMulti reports missing records as ErrNoSuchEntity in a
+	// MultiError.
+	GetMulti(c context.Context, kind string, names []string, dst interface{}) error
+	Put(c context.Context, kind, name string, src interface{}) error
+	PutMulti(c context.Context, kind string, names []string, src interface{}) error
+	Delete(c context.Context, kind, name string) error
+	// Names lists the records of a kind in order.
+	Names(c context.Context, kind string) ([]string, error)
+	// RunInTransaction runs f so the reads and writes it makes with
+	// tc happen as one unit.  f may be run more than once, so it
+	// shouldn't have other side effects.  On App Engine reads in f
+	// don't see its own writes, Names can't be used and at most 25
+	// records may be touched.
+	RunInTransaction(c context.Context, f func(tc context.Context) error) error
+}
+
+// An Attachment is a file sent along with a Message.
+type Attachment struct {
+	Name      string
+	Data      []byte
+	ContentID string
+}
+
+// A Message is an outbound email.
+type Message struct {
+	Sender      string
+	To          []string
+	Subject     string
+	Body        string
+	HTMLBody    string
+	Attachments []Attachment
+	// Headers are extra headers to send, e.g. X-Something.
+	Headers mail.Header
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(c context.Context, msg *Message) error
+}
+
+// Services are the things westspy needs from where it runs.
+type Services struct {
+	Context  func(r *http.Request) context.Context
+	Hostname func(c context.Context) string
+	HTTP     func(c context.Context) *http.Client
+	Log      func(c context.Context, l Level, format string, args ...interface{})
+
+	Cache Cache
+	Queue Queue
+	Blobs BlobStore
+	Mail  Mailer
+}
+
+// Default is the set of services in use.
+var Default = AppEngine()
+
+// Use replaces the services in use.  It should be called before
+// serving anything.
+func Use(s *Services) {
+	Default = s
+}
+
+// NewContext returns the context for a request.
+func NewContext(r *http.Request) context.Context {
+	return Default.Context(r)
+}
+
+// Hostname is the host name the site is served as.
+func Hostname(c context.Context) string {
+	return Default.Hostname(c)
+}
+
+// HTTPClient returns a client for outbound requests.
+func HTTPClient(c context.Context) *http.Client {
+	return Default.HTTP(c)
+}
+
+// GetJSON decodes the cached JSON value for key into v.
+func GetJSON(c context.Context, key string, v interface{}) error {
+	it, err := Default.Cache.Get(c, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(it.Value, v)
+}
+
+// SetJSON caches v as JSON under key.
+func SetJSON(c context.Context, key string, v interface{}, exp time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return Default.Cache.Set(c, &CacheItem{Key: key, Value: data, Expiration: exp})
+}
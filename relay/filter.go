@@ -0,0 +1,279 @@
+package relay
+
+import (
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// filterHeader records the filters' verdict on mail that gets
+// through them.
+const filterHeader = "X-Westspy-Filter"
+
+// Points for authentication results.
+var authScores = map[string]int{
+	"pass":      1,
+	"softfail":  -1,
+	"fail":      -3,
+	"permerror": -1,
+}
+
+// filterConfig is the "filter" section of the routing table.
+type filterConfig struct {
+	// Senders (someone@example.com) or domains (example.com)
+	// whose mail always passes or is always quarantined.
+	Allow []string `json:"allow,omitempty"`
+	Block []string `json:"block,omitempty"`
+	// Mail bigger than this many bytes is quarantined.  0 means no
+	// limit.
+	MaxSize int `json:"max_size,omitempty"`
+	// Mail scoring below this is quarantined.
+	MinScore int          `json:"min_score"`
+	Rules    []filterRule `json:"rules,omitempty"`
+	// The authserv-ids of our own mail servers.  Only an
+	// Authentication-Results header they added is believed.
+	AuthServIDs []string `json:"authserv_ids,omitempty"`
+}
+
+// A filterRule adds Score when Pattern matches a header, or the
+// decoded text and HTML bodies if no header is named.
+type filterRule struct {
+	Header  string `json:"header,omitempty"`
+	Pattern string `json:"pattern"`
+	Score   int    `json:"score"`
+
+	re *regexp.Regexp
+}
+
+func (fc *filterConfig) parse() error {
+	for _, l := range [][]string{fc.Allow, fc.Block, fc.AuthServIDs} {
+		for i := range l {
+			l[i] = strings.ToLower(l[i])
+		}
+	}
+	for i := range fc.Rules {
+		r := &fc.Rules[i]
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("filter rule %v: %v", r.Pattern, err)
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// A verdict is what the filters think of a message.
+type verdict struct {
+	Score            int
+	Notes            []string
+	Allowed, Blocked bool
+}
+
+func (v *verdict) add(score int, format string, args ...interface{}) {
+	v.Score += score
+	v.Notes = append(v.Notes, fmt.Sprintf("%s %+d", fmt.Sprintf(format, args...), score))
+}
+
+func (v *verdict) String() string {
+	var s string
+	switch {
+	case v.Allowed:
+		s = "allowed"
+	case v.Blocked:
+		s = "blocked"
+	default:
+		s = fmt.Sprintf("score=%d", v.Score)
+	}
+	if len(v.Notes) > 0 {
+		s += " (" + strings.Join(v.Notes, ", ") + ")"
+	}
+	return s
+}
+
+// A filter looks at a message's headers, its decoded text and its size
+// and updates the verdict.
+type filter func(fc *filterConfig, h mail.Header, body string, size int, v *verdict)
+
+// filters run in order until one allows or blocks the message.
+var filters = []filter{filterSize, filterLists, filterAuth, filterRules}
+
+// filterMessage runs the filter chain over a message's headers and
+// decoded body text and reports whether it should be let through.
+func filterMessage(fc *filterConfig, h mail.Header, body string, size int) (*verdict, bool) {
+	v := &verdict{}
+	for _, f := range filters {
+		f(fc, h, body, size, v)
+		if v.Allowed || v.Blocked {
+			break
+		}
+	}
+	return v, v.Allowed || (!v.Blocked && v.Score >= fc.MinScore)
+}
+
+// listed reports which entry of l, if any, covers addr.
+func listed(l []string, addr string) string {
+	domain := domainOf(addr)
+	for _, e := range l {
+		if e == addr || e == domain {
+			return e
+		}
+	}
+	return ""
+}
+
+// fromAddr is the lower-cased address mail claims to be from.
+func fromAddr(h mail.Header) (string, error) {
+	from, err := mail.ParseAddress(h.Get("from"))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(from.Address), nil
+}
+
+// Allowlisted mail must also pass SPF or DKIM for its own domain,
+// since anybody can write a From header.
+func filterLists(fc *filterConfig, h mail.Header, body string, size int, v *verdict) {
+	addr, err := fromAddr(h)
+	if err != nil {
+		v.add(-1, "unparseable sender")
+		return
+	}
+	if e := listed(fc.Allow, addr); e != "" {
+		if authenticated(fc, h, addr) {
+			v.Allowed = true
+			v.Notes = append(v.Notes, "allowlisted "+e)
+		} else {
+			v.Notes = append(v.Notes, "allowlisted "+e+" but not authenticated")
+		}
+	} else if e := listed(fc.Block, addr); e != "" {
+		v.Blocked = true
+		v.Notes = append(v.Notes, "blocklisted "+e)
+	}
+}
+
+func filterSize(fc *filterConfig, h mail.Header, body string, size int, v *verdict) {
+	if fc.MaxSize > 0 && size > fc.MaxSize {
+		v.Blocked = true
+		v.Notes = append(v.Notes, fmt.Sprintf("%d bytes is over %d", size, fc.MaxSize))
+	}
+}
+
+// An authResult is what a method (spf or dkim) found, and the domain
+// it speaks for.
+type authResult struct {
+	Result, Domain string
+}
+
+// stripComments removes the parenthesized comments from a header.
+func stripComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// domainOf is what follows the @ in an address, or s itself.
+func domainOf(s string) string {
+	return s[strings.LastIndex(s, "@")+1:]
+}
+
+// authResults finds the SPF and DKIM results in the topmost
+// Authentication-Results header, which our own server adds.  The
+// sender can write any others, so nothing is believed unless that
+// header names one of fc.AuthServIDs.
+func authResults(fc *filterConfig, h mail.Header) map[string]authResult {
+	rv := map[string]authResult{}
+	ars := h["Authentication-Results"]
+	if len(ars) == 0 {
+		return rv
+	}
+	parts := strings.Split(strings.ToLower(stripComments(ars[0])), ";")
+	id := strings.Fields(parts[0])
+	trusted := false
+	for _, t := range fc.AuthServIDs {
+		trusted = trusted || len(id) > 0 && id[0] == t
+	}
+	if !trusted {
+		return rv
+	}
+	for _, p := range parts[1:] {
+		fs := strings.Fields(p)
+		if len(fs) == 0 {
+			continue
+		}
+		kv := strings.SplitN(fs[0], "=", 2)
+		if len(kv) != 2 || (kv[0] != "spf" && kv[0] != "dkim") {
+			continue
+		}
+		ar := authResult{Result: kv[1]}
+		for _, prop := range fs[1:] {
+			pv := strings.SplitN(prop, "=", 2)
+			if len(pv) != 2 || ar.Domain != "" {
+				continue
+			}
+			switch pv[0] {
+			case "smtp.mailfrom", "header.d", "header.i":
+				ar.Domain = domainOf(pv[1])
+			}
+		}
+		// Of several DKIM signatures, a passing one counts.
+		if old, ok := rv[kv[0]]; !ok || (old.Result != "pass" && ar.Result == "pass") {
+			rv[kv[0]] = ar
+		}
+	}
+	return rv
+}
+
+// aligned reports whether an authenticated domain speaks for mail
+// from the other, allowing for subdomains either way.
+func aligned(domain, from string) bool {
+	return domain != "" && (domain == from ||
+		strings.HasSuffix(from, "."+domain) || strings.HasSuffix(domain, "."+from))
+}
+
+// authenticated reports whether SPF or DKIM vouches for addr's domain.
+func authenticated(fc *filterConfig, h mail.Header, addr string) bool {
+	for _, ar := range authResults(fc, h) {
+		if ar.Result == "pass" && aligned(ar.Domain, domainOf(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterAuth(fc *filterConfig, h mail.Header, body string, size int, v *verdict) {
+	res := authResults(fc, h)
+	for _, k := range []string{"spf", "dkim"} {
+		if score, ok := authScores[res[k].Result]; ok {
+			v.add(score, "%v=%v", k, res[k].Result)
+		}
+	}
+}
+
+func filterRules(fc *filterConfig, h mail.Header, body string, size int, v *verdict) {
+	for _, r := range fc.Rules {
+		if r.Header != "" {
+			for _, hv := range h[textproto.CanonicalMIMEHeaderKey(r.Header)] {
+				if r.re.MatchString(decodeHeader(hv)) {
+					v.add(r.Score, "%v matched %v", r.Header, r.Pattern)
+					break
+				}
+			}
+			continue
+		}
+		if r.re.MatchString(body) {
+			v.add(r.Score, "body matched %v", r.Pattern)
+		}
+	}
+}
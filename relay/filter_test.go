@@ -0,0 +1,186 @@
+package relay
+
+import (
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"context"
+)
+
+const testFilter = `{
+  "sender": "relay@example.com",
+  "rules": [
+    {"match": "keep?", "action": "store"},
+    {"match": "fwd", "to": ["dustin@example.com"]}
+  ],
+  "filter": {
+    "allow": ["friend@example.net"],
+    "block": ["Spammer.example.com"],
+    "max_size": 400,
+    "min_score": -2,
+    "authserv_ids": ["MX.example.com"],
+    "rules": [
+      {"header": "subject", "pattern": "(?i)lottery", "score": -3},
+      {"pattern": "unsubscribe", "score": -1}
+    ]
+  }
+}`
+
+func TestAuthResults(t *testing.T) {
+	fc := &filterConfig{AuthServIDs: []string{"mx.google.com"}}
+	tests := []struct {
+		name      string
+		ars       []string
+		spf, dkim authResult
+	}{
+		{"google", []string{"mx.google.com;\r\n" +
+			"       dkim=pass header.i=@example.com header.s=20161025;\r\n" +
+			"       spf=softfail (google.com: domain of transitioning someone@example.com; no) smtp.mailfrom=someone@example.com"},
+			authResult{"softfail", "example.com"}, authResult{"pass", "example.com"}},
+		{"versioned", []string{"mx.google.com 1; spf=pass smtp.mailfrom=Example.COM"},
+			authResult{"pass", "example.com"}, authResult{}},
+		{"second signature passes", []string{"mx.google.com; dkim=fail header.d=a.example; dkim=pass header.d=b.example"},
+			authResult{}, authResult{"pass", "b.example"}},
+		{"forged", []string{"mx.evil.example; spf=pass smtp.mailfrom=example.com; dkim=pass header.d=example.com"},
+			authResult{}, authResult{}},
+		{"forged below ours", []string{"mx.google.com; spf=fail smtp.mailfrom=example.com",
+			"mx.google.com; spf=pass smtp.mailfrom=example.com"},
+			authResult{"fail", "example.com"}, authResult{}},
+		{"ours below forged", []string{"mx.evil.example; spf=pass smtp.mailfrom=example.com",
+			"mx.google.com; spf=fail smtp.mailfrom=example.com"},
+			authResult{}, authResult{}},
+		{"none", nil, authResult{}, authResult{}},
+	}
+	for _, test := range tests {
+		res := authResults(fc, mail.Header{"Authentication-Results": test.ars,
+			"Received-Spf": {"pass (forged)"}})
+		if res["spf"] != test.spf || res["dkim"] != test.dkim {
+			t.Errorf("%v: got %+v, want spf %+v, dkim %+v", test.name, res, test.spf, test.dkim)
+		}
+	}
+}
+
+func TestFilterMessage(t *testing.T) {
+	rt, err := parseRoutes([]byte(testFilter))
+	if err != nil {
+		t.Fatalf("Error parsing routes: %v", err)
+	}
+
+	tests := []struct {
+		name, headers, body string
+		ok                  bool
+		verdict             string
+	}{
+		{"plain", "From: someone@example.com\r\nSubject: hi\r\n", "Hi.", true, "score=0"},
+		{"authenticated", "From: someone@example.com\r\n" +
+			"Authentication-Results: mx.example.com; spf=pass; dkim=pass\r\n", "Hi.",
+			true, "score=2 (spf=pass +1, dkim=pass +1)"},
+		{"spf fail", "From: someone@example.com\r\n" +
+			"Authentication-Results: mx.example.com; spf=fail\r\n", "Hi.",
+			false, "score=-3 (spf=fail -3)"},
+		{"forged pass", "From: someone@example.com\r\nSubject: You won the LOTTERY\r\n" +
+			"Authentication-Results: mx.evil.example; spf=pass; dkim=pass\r\n", "Hi.",
+			false, "score=-3 (subject matched (?i)lottery -3)"},
+		{"lottery", "From: someone@example.com\r\nSubject: You won the LOTTERY\r\n", "Hi.",
+			false, "score=-3 (subject matched (?i)lottery -3)"},
+		{"just bulk", "From: someone@example.com\r\nSubject: news\r\n", "Click to unsubscribe.",
+			true, "score=-1 (body matched unsubscribe -1)"},
+		{"blocked domain", "From: Bob <bob@spammer.example.com>\r\n", "Hi.",
+			false, "blocked (blocklisted spammer.example.com)"},
+		{"allowed", "From: Friend@Example.net\r\nSubject: lottery\r\n" +
+			"Authentication-Results: mx.example.com; spf=fail smtp.mailfrom=example.net;" +
+			" dkim=pass header.d=mail.example.net\r\n", "Hi.",
+			true, "allowed (allowlisted friend@example.net)"},
+		{"allowlisted unaligned", "From: Friend@Example.net\r\nSubject: lottery\r\n" +
+			"Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=evil.example\r\n", "Hi.",
+			true, "score=-2 (allowlisted friend@example.net but not authenticated, spf=pass +1, subject"},
+		{"allowlisted forged", "From: Friend@Example.net\r\nSubject: lottery\r\n" +
+			"Authentication-Results: mx.evil.example; dkim=pass header.d=example.net\r\n", "Hi.",
+			false, "score=-3 (allowlisted friend@example.net but not authenticated, subject"},
+		{"allowlisted too big", "From: Friend@Example.net\r\n" +
+			"Authentication-Results: mx.example.com; dkim=pass header.d=example.net\r\n", strings.Repeat("x", 400),
+			false, "blocked (5"},
+		{"no sender", "Subject: hi\r\n", "Hi.", true, "score=-1 (unparseable sender -1)"},
+		{"base64 bulk", "From: someone@example.com\r\n" +
+			"Content-Transfer-Encoding: base64\r\n", "Q2xpY2sgdG8gdW5zdWJzY3JpYmUu\r\n",
+			true, "score=-1 (body matched unsubscribe -1)"},
+		{"quoted-printable bulk", "From: someone@example.com\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n", "Click to un=\r\nsubscribe.\r\n",
+			true, "score=-1 (body matched unsubscribe -1)"},
+		{"too big", "From: someone@example.com\r\n", strings.Repeat("x", 400),
+			false, "blocked (4"},
+	}
+	for _, test := range tests {
+		data := test.headers + "\r\n" + test.body
+		m, err := mail.ReadMessage(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		text := &msgExtractor{}
+		text.parse(context.Background(), []byte(data))
+		v, ok := filterMessage(&rt.Filter, m.Header, text.body+"\n"+text.hbody, len(data))
+		if ok != test.ok || !strings.HasPrefix(v.String(), test.verdict) {
+			t.Errorf("%v: got %v, %q, want %v, %q", test.name, ok, v, test.ok, test.verdict)
+		}
+	}
+}
+
+func TestFilteredMail(t *testing.T) {
+	dir, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	if err := storeRoutes(c, []byte(testFilter)); err != nil {
+		t.Fatalf("Error storing routes: %v", err)
+	}
+	extendGrant(c, "keep1", time.Hour, time.Now())
+
+	if code := receive("keep1"); code != http.StatusAccepted {
+		t.Errorf("Expected mail to be accepted, got %v", code)
+	}
+	kept, _ := listMessages(c, mailboxKind)
+	if len(kept) != 1 || !strings.HasPrefix(messageText(c, mailboxKind, kept[0].ID), (filterHeader+": score=0\r\nFrom: ")) {
+		t.Fatalf("Expected stored mail with a verdict, got %+v", kept)
+	}
+
+	extendGrant(c, "fwd", time.Hour, time.Now())
+	if code := receive("fwd"); code != http.StatusAccepted {
+		t.Errorf("Expected mail to be forwarded, got %v", code)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(spooled) != 1 {
+		t.Fatalf("Expected one message forwarded, got %v", spooled)
+	}
+	f, err := os.Open(spooled[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fwd, err := mail.ReadMessage(f)
+	if err != nil || fwd.Header.Get(filterHeader) != "score=0" {
+		t.Errorf("Expected the verdict on the forwarded mail, got %v, %v", fwd, err)
+	}
+
+	spam := strings.Replace(testMail, "Subject: hello", "Subject: lottery", 1)
+	if code := receiveMessage("keep1", spam); code != http.StatusAccepted {
+		t.Errorf("Expected spam to be accepted, got %v", code)
+	}
+	q, _ := listMessages(c, quarantineKind)
+	if len(q) != 1 || !strings.HasPrefix(q[0].Reason, "filtered: score=-3") ||
+		messageText(c, quarantineKind, q[0].ID) != spam {
+		t.Errorf("Expected spam quarantined, got %+v", q)
+	}
+
+	big := testMail + strings.Repeat("x", 400)
+	receiveMessage("keep1", big)
+	q, _ = listMessages(c, quarantineKind)
+	if len(q) != 2 || !strings.HasPrefix(q[0].Reason, "filtered: blocked") ||
+		messageText(c, quarantineKind, q[0].ID) != big {
+		t.Errorf("Expected big mail quarantined, got %+v", q[0].storedMessage)
+	}
+}
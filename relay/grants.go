@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+const grantKind = "MailGrant"
+
+// A grant lets mail for an address in until it expires.
+type grant struct {
+	Addr    string
+	Created time.Time
+	Expires time.Time
+}
+
+func (g *grant) live(now time.Time) bool {
+	return now.Before(g.Expires)
+}
+
+type grantsByExpiry []*grant
+
+func (g grantsByExpiry) Len() int { return len(g) }
+
+func (g grantsByExpiry) Less(i, j int) bool {
+	return g[i].Expires.After(g[j].Expires)
+}
+
+func (g grantsByExpiry) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
+
+// lookupGrant finds the grant for addr, or nil if there isn't one.
+func lookupGrant(c context.Context, addr string) (*grant, error) {
+	g := &grant{}
+	switch err := platform.Default.Blobs.Get(c, grantKind, addr, g); err {
+	case nil:
+		return g, nil
+	case platform.ErrNoSuchEntity:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// extendGrant makes addr live for d more, counting from now if it
+// isn't live already.
+func extendGrant(c context.Context, addr string, d time.Duration, now time.Time) (*grant, error) {
+	g := &grant{}
+	switch err := platform.Default.Blobs.Get(c, grantKind, addr, g); err {
+	case nil:
+	case platform.ErrNoSuchEntity:
+		g = &grant{Addr: addr, Created: now}
+	default:
+		return nil, err
+	}
+	if !g.live(now) {
+		g.Expires = now
+	}
+	g.Expires = g.Expires.Add(d)
+	return g, platform.Default.Blobs.Put(c, grantKind, addr, g)
+}
+
+func listGrants(c context.Context) ([]*grant, error) {
+	names, err := platform.Default.Blobs.Names(c, grantKind)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]*grant, len(names))
+	for i := range rv {
+		rv[i] = &grant{}
+	}
+	if err := platform.Default.Blobs.GetMulti(c, grantKind, names, rv); err != nil {
+		return nil, err
+	}
+	sort.Sort(grantsByExpiry(rv))
+	return rv, nil
+}
+
+type grantView struct {
+	*grant
+	Remaining time.Duration
+}
+
+// EnableMail lists addresses and turns them on for a while.  POSTing
+// addr and duration enables or extends an address, and revoke turns
+// one off.
+func EnableMail(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+	now := time.Now()
+
+	if r.Method == "POST" {
+		if addr := strings.ToLower(r.FormValue("revoke")); addr != "" {
+			log.Infof(c, "Revoking mail for %q", addr)
+			err := platform.Default.Blobs.Delete(c, grantKind, addr)
+			if err != nil && err != platform.ErrNoSuchEntity {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		} else {
+			addr := strings.ToLower(strings.TrimSpace(r.FormValue("addr")))
+			d, err := time.ParseDuration(r.FormValue("duration"))
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if addr == "" || d <= 0 {
+				http.Error(w, "need an address and a positive duration", 400)
+				return
+			}
+			g, err := extendGrant(c, addr, d, now)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			log.Infof(c, "Mail for %q enabled until %v", addr, g.Expires)
+		}
+		http.Redirect(w, r, "/admin/enableMail", http.StatusFound)
+		return
+	}
+
+	grants, err := listGrants(c)
+	if err != nil {
+		http.Error(w, "Error loading addresses: "+err.Error(), 500)
+		return
+	}
+	var live, expired []grantView
+	for _, g := range grants {
+		if g.live(now) {
+			live = append(live, grantView{g, g.Expires.Sub(now).Truncate(time.Second)})
+		} else {
+			expired = append(expired, grantView{g, 0})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "enable.html", struct {
+		Live, Expired []grantView
+	}{live, expired})
+	if err != nil {
+		log.Errorf(c, "Error rendering enabled addresses: %v", err)
+	}
+}
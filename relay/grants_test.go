@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"context"
+
+	"platform"
+)
+
+const testMail = "From: someone@example.com\r\n" +
+	"To: temp@west.spy.net\r\n" +
+	"Subject: hello\r\n" +
+	"\r\n" +
+	"Hi there.\r\n"
+
+// useLocal points the platform at a scratch directory with the test
+// routes installed.
+func useLocal(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := platform.Local(dir, "localhost", nil)
+	if !testing.Verbose() {
+		s.Log = func(context.Context, platform.Level, string, ...interface{}) {}
+	}
+	platform.Use(s)
+	if err := storeRoutes(context.Background(), []byte(testRoutes)); err != nil {
+		t.Fatalf("Error storing routes: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func receive(to string) int {
+	return receiveMessage(to, testMail)
+}
+
+func receiveMessage(to, msg string) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/_ah/mail/"+to+"@west.spy.net", strings.NewReader(msg))
+	IncomingMail(w, r)
+	return w.Code
+}
+
+func postEnable(t *testing.T, form url.Values) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin/enableMail", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	EnableMail(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Error posting %v: %v %s", form, w.Code, w.Body)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	dir, done := useLocal(t)
+	defer done()
+
+	postEnable(t, url.Values{"addr": {"Dustin"}, "duration": {"1h"}})
+	if code := receive("dustin"); code != http.StatusAccepted {
+		t.Errorf("Expected mail to be accepted, got %v", code)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(spooled) != 1 {
+		t.Errorf("Expected one message forwarded, got %v", spooled)
+	}
+
+	postEnable(t, url.Values{"revoke": {"dustin"}})
+	if g, err := lookupGrant(context.Background(), "dustin"); g != nil || err != nil {
+		t.Errorf("Expected grant to be revoked, got %+v, %v", g, err)
+	}
+}
+
+func TestExtendGrant(t *testing.T) {
+	_, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	g, err := extendGrant(c, "temp", time.Hour, now)
+	if err != nil {
+		t.Fatalf("Error granting: %v", err)
+	}
+	if !g.Created.Equal(now) || !g.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected grant: %+v", g)
+	}
+
+	// Extending a live grant adds to what's left.
+	g, _ = extendGrant(c, "temp", time.Hour, now.Add(30*time.Minute))
+	if !g.Expires.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("Expected extension to 14:00, got %v", g.Expires)
+	}
+
+	later := now.Add(3 * time.Hour)
+	if g, err := lookupGrant(c, "temp"); g == nil || g.live(later) {
+		t.Errorf("Expected grant to have expired, got %+v, %v", g, err)
+	}
+
+	// An expired one starts over from now.
+	g, _ = extendGrant(c, "temp", time.Hour, later)
+	if !g.Expires.Equal(later.Add(time.Hour)) || !g.Created.Equal(now) {
+		t.Errorf("Unexpected renewed grant: %+v", g)
+	}
+	if g, err := lookupGrant(c, "temp"); g == nil || !g.live(later) {
+		t.Errorf("Expected renewed grant to be live, got %+v, %v", g, err)
+	}
+}
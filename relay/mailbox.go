@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"time"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+const mailboxKind = "Mail"
+
+// maxStoredData is as much of a message as is kept.  Datastore
+// entities are limited to 1MB, so bigger mail loses the end of its
+// body.
+var maxStoredData = 900 << 10
+
+// storedMessage is a message kept by a store rule or quarantined.
+// Its raw text is a separate messageData record under the same name so
+// listing mail doesn't load it all.
+type storedMessage struct {
+	To       string
+	From     string
+	Subject  string
+	Reason   string // why it was quarantined
+	Received time.Time
+	Size     int // as received
+	Kept     int // bytes of it in Data
+	// Data is filled in by getMessage.  Only records from before
+	// messageData existed hold it themselves.
+	Data []byte `datastore:",noindex"`
+}
+
+// messageData is the raw text of a storedMessage.
+type messageData struct {
+	Data []byte `datastore:",noindex"`
+}
+
+// dataKind is where the raw text of kind's messages is kept.
+func dataKind(kind string) string {
+	return kind + "Data"
+}
+
+// Truncated reports whether only part of the message was kept.
+func (m *storedMessage) Truncated() bool {
+	if m.Kept == 0 {
+		return m.Size > len(m.Data)
+	}
+	return m.Size > m.Kept
+}
+
+// trimMessage cuts a raw message down to at most max bytes, noting at
+// the end that it did.
+func trimMessage(data []byte, max int) []byte {
+	if len(data) <= max {
+		return data
+	}
+	note := fmt.Sprintf("\r\n\r\n[Truncated: the message was %d bytes.]\r\n", len(data))
+	rv := make([]byte, 0, max)
+	rv = append(rv, data[:max-len(note)]...)
+	return append(rv, note...)
+}
+
+type mailboxEntry struct {
+	ID string
+	*storedMessage
+}
+
+type entriesByReceived []mailboxEntry
+
+func (e entriesByReceived) Len() int { return len(e) }
+
+func (e entriesByReceived) Less(i, j int) bool {
+	return e[i].Received.After(e[j].Received)
+}
+
+func (e entriesByReceived) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+// keepMessage stores a message for addr under kind, noting why.
+func keepMessage(c context.Context, kind, addr string, h mail.Header, data []byte, reason string) error {
+	now := time.Now()
+	md := &messageData{trimMessage(data, maxStoredData)}
+	m := &storedMessage{
+		To:       addr,
+		From:     h.Get("from"),
+		Subject:  h.Get("subject"),
+		Reason:   reason,
+		Received: now,
+		Size:     len(data),
+		Kept:     len(md.Data),
+	}
+	id := fmt.Sprintf("%020d", now.UnixNano())
+	log.Infof(c, "Keeping %v mail for %q as %v", kind, addr, id)
+	// The text goes first so anything listed can be read.
+	if err := platform.Default.Blobs.Put(c, dataKind(kind), id, md); err != nil {
+		return err
+	}
+	return platform.Default.Blobs.Put(c, kind, id, m)
+}
+
+func getMessage(c context.Context, kind, id string) (*storedMessage, error) {
+	m := &storedMessage{}
+	if err := platform.Default.Blobs.Get(c, kind, id, m); err != nil {
+		return nil, err
+	}
+	md := &messageData{}
+	switch err := platform.Default.Blobs.Get(c, dataKind(kind), id, md); err {
+	case nil:
+		m.Data = md.Data
+	case platform.ErrNoSuchEntity:
+	default:
+		return nil, err
+	}
+	return m, nil
+}
+
+// deleteMessage removes a message and its text.
+func deleteMessage(c context.Context, kind, id string) error {
+	if err := platform.Default.Blobs.Delete(c, kind, id); err != nil {
+		return err
+	}
+	err := platform.Default.Blobs.Delete(c, dataKind(kind), id)
+	if err == platform.ErrNoSuchEntity {
+		err = nil
+	}
+	return err
+}
+
+// listMessages returns the messages of a kind, newest first, without
+// their text.
+func listMessages(c context.Context, kind string) ([]mailboxEntry, error) {
+	names, err := platform.Default.Blobs.Names(c, kind)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*storedMessage, len(names))
+	for i := range msgs {
+		msgs[i] = &storedMessage{}
+	}
+	if err := platform.Default.Blobs.GetMulti(c, kind, names, msgs); err != nil {
+		return nil, err
+	}
+	rv := make([]mailboxEntry, len(names))
+	for i := range names {
+		rv[i] = mailboxEntry{names[i], msgs[i]}
+	}
+	sort.Sort(entriesByReceived(rv))
+	return rv, nil
+}
+
+// HandleMailbox lists stored mail.  id downloads a message, and
+// POSTing delete removes one.
+func HandleMailbox(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	if r.Method == "POST" {
+		if err := deleteMessage(c, mailboxKind, r.FormValue("delete")); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+		return
+	}
+
+	if id := r.FormValue("id"); id != "" {
+		m, err := getMessage(c, mailboxKind, id)
+		switch err {
+		case nil:
+		case platform.ErrNoSuchEntity:
+			http.NotFound(w, r)
+			return
+		default:
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", "attachment; filename="+id+".eml")
+		w.Write(m.Data)
+		return
+	}
+
+	msgs, err := listMessages(c, mailboxKind)
+	if err != nil {
+		http.Error(w, "Error loading mailbox: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := getTemplates().ExecuteTemplate(w, "mailbox.html", msgs); err != nil {
+		log.Errorf(c, "Error rendering mailbox: %v", err)
+	}
+}
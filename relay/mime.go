@@ -0,0 +1,216 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+// How deep multiparts may nest before we stop looking.
+const maxMIMEDepth = 16
+
+// Extensions for parts that don't name themselves.  App Engine
+// decides whether it'll send an attachment by its extension.
+var partExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"text/plain":      ".txt",
+	"text/html":       ".html",
+	"text/calendar":   ".ics",
+	"application/pdf": ".pdf",
+	"message/rfc822":  ".eml",
+}
+
+// cp1252 is what windows-1252 has in 0x80-0x9f, where ISO 8859-1 has
+// control characters.  Zeros are unassigned.
+var cp1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// toUTF8 converts text in the named charset to UTF-8.  Charsets we
+// don't know are passed through with anything that isn't UTF-8
+// replaced.
+func toUTF8(charset string, b []byte) (string, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return strings.ToValidUTF8(string(b), "�"), nil
+	case "iso-8859-1", "latin1", "iso_8859-1", "windows-1252", "cp1252":
+		rs := make([]rune, len(b))
+		for i, c := range b {
+			rs[i] = rune(c)
+			if c >= 0x80 && c < 0xa0 && cp1252[c-0x80] != 0 {
+				rs[i] = cp1252[c-0x80]
+			}
+		}
+		return string(rs), nil
+	}
+	return strings.ToValidUTF8(string(b), "�"), fmt.Errorf("unknown charset %q", charset)
+}
+
+// charsetReader lets mime.WordDecoder read the charsets toUTF8 knows.
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s, err := toUTF8(charset, b)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(s), nil
+}
+
+// decodeHeader decodes RFC 2047 encoded words in a header value,
+// returning it as is if it can't.
+func decodeHeader(s string) string {
+	d := &mime.WordDecoder{CharsetReader: charsetReader}
+	if rv, err := d.DecodeHeader(s); err == nil {
+		return rv
+	}
+	return s
+}
+
+// decodePart undoes a part's Content-Transfer-Encoding.
+func decodePart(h textproto.MIMEHeader, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &spaceStripper{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// spaceStripper drops the spaces and tabs some mailers leave in
+// base64.  The decoder itself skips newlines.
+type spaceStripper struct {
+	r io.Reader
+}
+
+func (s *spaceStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, c := range p[:n] {
+		if c != ' ' && c != '\t' {
+			p[j] = c
+			j++
+		}
+	}
+	return j, err
+}
+
+// msgExtractor walks a message's MIME tree, collecting the first text
+// and HTML bodies as UTF-8 and everything else as attachments.
+type msgExtractor struct {
+	body, hbody string
+	atts        []platform.Attachment
+}
+
+// parse extracts the parts of a raw message.  It keeps what it found
+// before any error.
+func (m *msgExtractor) parse(c context.Context, data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return m.walk(c, textproto.MIMEHeader(msg.Header), msg.Body, 0)
+}
+
+func (m *msgExtractor) walk(c context.Context, h textproto.MIMEHeader, r io.Reader, depth int) error {
+	ctype, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 says untyped parts are US-ASCII text.
+		ctype, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(ctype, "multipart/") {
+		if depth >= maxMIMEDepth {
+			return fmt.Errorf("multiparts nested over %d deep", maxMIMEDepth)
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			switch err {
+			case nil:
+			case io.EOF:
+				return nil
+			default:
+				return err
+			}
+			if err := m.walk(c, p.Header, p, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data := slurp(c, decodePart(h, r))
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	name = decodeHeader(name)
+
+	if disposition != "attachment" && name == "" {
+		switch {
+		case ctype == "text/plain" && m.body == "":
+			m.body = m.text(c, params["charset"], data)
+			return nil
+		case ctype == "text/html" && m.hbody == "":
+			m.hbody = m.text(c, params["charset"], data)
+			return nil
+		}
+	}
+
+	if name == "" {
+		ext, ok := partExtensions[ctype]
+		if !ok {
+			ext = ".bin"
+		}
+		name = fmt.Sprintf("part-%d%s", len(m.atts)+1, ext)
+	}
+	log.Infof(c, "Got %v part named %v", ctype, name)
+	m.atts = append(m.atts, platform.Attachment{
+		Name:      name,
+		Data:      data,
+		ContentID: h.Get("Content-Id"),
+	})
+	return nil
+}
+
+func (m *msgExtractor) text(c context.Context, charset string, data []byte) string {
+	s, err := toUTF8(charset, data)
+	if err != nil {
+		log.Infof(c, "Error converting text: %v", err)
+	}
+	return s
+}
+
+// parsePlain uses everything after the headers as the body, for mail
+// the walk couldn't make sense of.
+func (m *msgExtractor) parsePlain(c context.Context, r io.Reader) error {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	_, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	m.body = strings.ToValidUTF8(string(slurp(c, tr.R)), "�")
+	return nil
+}
@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"context"
+)
+
+type expectedAtt struct {
+	name, cid, prefix string
+}
+
+func TestMIMEFixtures(t *testing.T) {
+	_, done := useLocal(t)
+	defer done()
+
+	tests := []struct {
+		file, subject, body, hbody string
+		atts                       []expectedAtt
+		err                        bool
+	}{
+		{"plain-qp-latin1.eml", "Café tonight",
+			"Meet at the café at 8?  It's a long line that the sender's mailer wrapped with a soft break.\r\n",
+			"", nil, false},
+		{"untyped.eml", "old school", "Just some text.\n", "", nil, false},
+		{"cp1252-8bit.eml", "quotes", "“Quoted” – and 5€.\r\n", "", nil, false},
+		{"alternative-base64.eml", "Grüße", "Plain text with ünïcödé.\n",
+			`<p style="color: red">HTML with ünïcödé.</p>`, nil, false},
+		{"mixed-related-inline.eml", "picture", "See the picture.",
+			`<p>See <img src="cid:pic1@example.com"></p>`,
+			[]expectedAtt{
+				{"part-1.png", "<pic1@example.com>", "\x89PNG\r\n"},
+				{"résumé.pdf", "", "%PDF-1.4"},
+				{"part-3.txt", "", "Sent from my phone."},
+			}, false},
+		{"forwarded-rfc822.eml", "Fwd: hello", "See below.", "",
+			[]expectedAtt{{"part-1.eml", "", "From: other@example.com\n"}}, false},
+		{"truncated.eml", "cut off", "Made it.", "",
+			[]expectedAtt{{"data.bin", "", "\x00\x01\x02"}}, true},
+	}
+
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := &msgExtractor{}
+		err = m.parse(context.Background(), data)
+		if (err != nil) != test.err {
+			t.Errorf("%v: unexpected error: %v", test.file, err)
+		}
+
+		h, _ := mail.ReadMessage(bytes.NewReader(data))
+		if got := decodeHeader(h.Header.Get("subject")); got != test.subject {
+			t.Errorf("%v: subject = %q, want %q", test.file, got, test.subject)
+		}
+		if m.body != test.body {
+			t.Errorf("%v: body = %q, want %q", test.file, m.body, test.body)
+		}
+		if m.hbody != test.hbody {
+			t.Errorf("%v: html = %q, want %q", test.file, m.hbody, test.hbody)
+		}
+
+		if len(m.atts) != len(test.atts) {
+			t.Errorf("%v: got %v attachments, want %v", test.file, len(m.atts), len(test.atts))
+			continue
+		}
+		for i, exp := range test.atts {
+			a := m.atts[i]
+			if a.Name != exp.name || a.ContentID != exp.cid ||
+				!strings.HasPrefix(string(a.Data), exp.prefix) {
+				t.Errorf("%v: attachment %v = %q %q %q, want %+v",
+					test.file, i, a.Name, a.ContentID, a.Data, exp)
+			}
+		}
+	}
+}
+
+func TestMIMEDepth(t *testing.T) {
+	_, done := useLocal(t)
+	defer done()
+
+	var b strings.Builder
+	b.WriteString("From: someone@example.com\r\n")
+	for i := 0; i <= maxMIMEDepth; i++ {
+		b.WriteString("Content-Type: multipart/mixed; boundary=b" + string(rune('a'+i)) +
+			"\r\n\r\n--b" + string(rune('a'+i)) + "\r\n")
+	}
+	b.WriteString("Content-Type: text/plain\r\n\r\ndeep\r\n")
+
+	m := &msgExtractor{}
+	if err := m.parse(context.Background(), []byte(b.String())); err == nil {
+		t.Errorf("Expected an error for deeply nested multiparts")
+	}
+	if m.body != "" {
+		t.Errorf("Expected nothing from too deep, got %q", m.body)
+	}
+}
+
+func TestDeliverHTMLOnly(t *testing.T) {
+	dir, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	const html = "From: someone@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>Hi there.</p>\r\n"
+	rt, err := loadRoutes(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := mail.ReadMessage(strings.NewReader(html))
+	if _, err := deliver(c, rt, "dustin", msg.Header, []byte(html), ""); err != nil {
+		t.Fatalf("Error delivering: %v", err)
+	}
+
+	spooled, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(spooled) != 1 {
+		t.Fatalf("Expected one message forwarded, got %v", spooled)
+	}
+	data, err := ioutil.ReadFile(spooled[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("text/html")) || bytes.Contains(data, []byte("text/plain")) {
+		t.Errorf("Expected only the HTML body forwarded, got\n%s", data)
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	if s, err := toUTF8("ISO-8859-1", []byte("caf\xe9")); s != "café" || err != nil {
+		t.Errorf("Latin-1: %q, %v", s, err)
+	}
+	if s, err := toUTF8("utf-8", []byte("bad \xff byte")); s != "bad � byte" || err != nil {
+		t.Errorf("Invalid UTF-8: %q, %v", s, err)
+	}
+	if s, err := toUTF8("koi8-r", []byte("ok")); s != "ok" || err == nil {
+		t.Errorf("Unknown charset: %q, %v", s, err)
+	}
+}
@@ -0,0 +1,140 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+const (
+	quarantineKind = "MailQuarantine"
+	// How long quarantined mail is kept before PurgeQuarantine
+	// removes it.
+	quarantineRetention = 14 * 24 * time.Hour
+)
+
+// release delivers a quarantined message as if its address had been
+// enabled when it arrived, and removes it from the quarantine.
+func release(c context.Context, id string) (string, error) {
+	m, err := getMessage(c, quarantineKind, id)
+	if err != nil {
+		return "", err
+	}
+	inmsg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return "", err
+	}
+	rt, err := loadRoutes(c)
+	if err != nil {
+		return "", err
+	}
+
+	if rule := rt.match(m.To); rule == nil || rule.Action == actionDrop {
+		return "", fmt.Errorf("the routes drop mail for %v", m.To)
+	}
+	action, err := deliver(c, rt, m.To, inmsg.Header, m.Data, "")
+	if err != nil {
+		return "", err
+	}
+	log.Infof(c, "Released %v for %q (%v)", id, m.To, action)
+	if err := deleteMessage(c, quarantineKind, id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Released mail for %v (%v)", m.To, action), nil
+}
+
+// HandleQuarantine lists mail for addresses that weren't enabled.  id
+// shows a message, and POSTing release or delete with an id forwards
+// or removes one.
+func HandleQuarantine(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	var msg string
+	if r.Method == "POST" {
+		var err error
+		if id := r.FormValue("release"); id != "" {
+			msg, err = release(c, id)
+		} else {
+			id = r.FormValue("delete")
+			err = deleteMessage(c, quarantineKind, id)
+			msg = "Deleted " + id
+		}
+		if err != nil {
+			msg = "Error: " + err.Error()
+		}
+	}
+
+	if id := r.FormValue("id"); id != "" {
+		m, err := getMessage(c, quarantineKind, id)
+		switch err {
+		case nil:
+		case platform.ErrNoSuchEntity:
+			http.NotFound(w, r)
+			return
+		default:
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(m.Data)
+		return
+	}
+
+	msgs, err := listMessages(c, quarantineKind)
+	if err != nil {
+		http.Error(w, "Error loading quarantine: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "quarantine.html", struct {
+		Message  string
+		Messages []mailboxEntry
+		Days     int
+	}{msg, msgs, int(quarantineRetention / (24 * time.Hour))})
+	if err != nil {
+		log.Errorf(c, "Error rendering quarantine: %v", err)
+	}
+}
+
+// PurgeQuarantine removes quarantined mail older than the retention
+// period.  Messages are named for when they arrived, so only the names
+// need loading.
+func PurgeQuarantine(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	names, err := platform.Default.Blobs.Names(c, quarantineKind)
+	if err != nil {
+		http.Error(w, "Error loading quarantine: "+err.Error(), 500)
+		return
+	}
+
+	cutoff := time.Now().Add(-quarantineRetention).UnixNano()
+	var errs platform.MultiError
+	for _, id := range names {
+		ns, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			log.Warningf(c, "Unexpected quarantined mail name %q", id)
+			continue
+		}
+		if ns < cutoff {
+			log.Infof(c, "Purging quarantined mail %v", id)
+			if err := deleteMessage(c, quarantineKind, id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		http.Error(w, errs.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
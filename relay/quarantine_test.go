@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"context"
+
+	"platform"
+)
+
+// messageText is what getMessage has kept of a message.
+func messageText(c context.Context, kind, id string) string {
+	m, err := getMessage(c, kind, id)
+	if err != nil {
+		return err.Error()
+	}
+	return string(m.Data)
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	if code := receive("dustin"); code != http.StatusAccepted {
+		t.Errorf("Expected mail for a disabled address to be quarantined, got %v", code)
+	}
+	msgs, err := listMessages(c, quarantineKind)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Expected one quarantined message, got %v, %v", msgs, err)
+	}
+	m := msgs[0]
+	if m.To != "dustin" || m.From != "someone@example.com" || m.Subject != "hello" ||
+		m.Reason != "not enabled" || m.Data != nil || messageText(c, quarantineKind, m.ID) != testMail {
+		t.Errorf("Unexpected quarantined message: %+v", m.storedMessage)
+	}
+
+	// Expired grants say so.
+	extendGrant(c, "bob-x", time.Hour, time.Now().Add(-2*time.Hour))
+	receive("bob-x")
+	msgs, _ = listMessages(c, quarantineKind)
+	if len(msgs) != 2 || !strings.HasPrefix(msgs[0].Reason, "expired ") {
+		t.Errorf("Expected an expired message first, got %+v", msgs)
+	}
+
+	msg, err := release(c, m.ID)
+	if err != nil {
+		t.Fatalf("Error releasing: %v", err)
+	}
+	if msg != "Released mail for dustin (forward)" {
+		t.Errorf("Unexpected release message: %q", msg)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(spooled) != 1 {
+		t.Errorf("Expected the released message forwarded, got %v", spooled)
+	}
+	if _, err := getMessage(c, quarantineKind, m.ID); err != platform.ErrNoSuchEntity {
+		t.Errorf("Expected released message to leave quarantine, got %v", err)
+	}
+
+	// spam is dropped by the routes, so releasing it does nothing.
+	receive("spam")
+	msgs, _ = listMessages(c, quarantineKind)
+	if _, err := release(c, msgs[0].ID); err == nil {
+		t.Errorf("Expected an error releasing dropped mail")
+	}
+	if _, err := getMessage(c, quarantineKind, msgs[0].ID); err != nil {
+		t.Errorf("Expected unreleased message to stay, got %v", err)
+	}
+}
+
+func TestPurgeQuarantine(t *testing.T) {
+	_, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	receive("new")
+	old := &storedMessage{To: "old", Received: time.Now().Add(-quarantineRetention - time.Hour)}
+	if err := platform.Default.Blobs.Put(c, quarantineKind, "0", old); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	PurgeQuarantine(w, httptest.NewRequest("POST", "/cron/mail/purge", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Error purging: %v %s", w.Code, w.Body)
+	}
+	msgs, _ := listMessages(c, quarantineKind)
+	if len(msgs) != 1 || msgs[0].To != "new" {
+		t.Errorf("Expected only new mail left, got %+v", msgs)
+	}
+	if names, _ := platform.Default.Blobs.Names(c, dataKind(quarantineKind)); len(names) != 1 {
+		t.Errorf("Expected only new mail's text left, got %v", names)
+	}
+}
+
+func TestQuarantineTruncates(t *testing.T) {
+	_, done := useLocal(t)
+	defer done()
+	c := context.Background()
+
+	defer func(old int) { maxStoredData = old }(maxStoredData)
+	maxStoredData = 200
+
+	big := testMail + strings.Repeat("x", 1000)
+	if code := receiveMessage("nobody", big); code != http.StatusAccepted {
+		t.Errorf("Expected big mail to be quarantined, got %v", code)
+	}
+	q, _ := listMessages(c, quarantineKind)
+	if len(q) != 1 {
+		t.Fatalf("Expected one quarantined message, got %v", len(q))
+	}
+	m, err := getMessage(c, quarantineKind, q[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Data) != 200 || m.Size != len(big) || !m.Truncated() ||
+		!strings.HasPrefix(string(m.Data), testMail) ||
+		!strings.HasSuffix(string(m.Data), "[Truncated: the message was 1079 bytes.]\r\n") {
+		t.Errorf("Unexpected truncated message: %v bytes of %v: %q", len(m.Data), m.Size, m.Data)
+	}
+
+	receive("nobody")
+	q, _ = listMessages(c, quarantineKind)
+	if q[0].Truncated() || messageText(c, quarantineKind, q[0].ID) != testMail {
+		t.Errorf("Small mail shouldn't be truncated: %+v", q[0].storedMessage)
+	}
+}
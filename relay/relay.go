@@ -0,0 +1,173 @@
+// Package relay forwards mail sent to west.spy.net addresses.
+//
+// Addresses are only live once enabled from /admin/enableMail, and
+// stay live until their grant expires (see grants.go).  Mail for
+// other addresses is quarantined for review (see quarantine.go).  What
+// happens to mail for a live address is decided by the routing table
+// (see routes.go).
+package relay
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+const tmplGlob = "templates/relay/*.html"
+
+var (
+	templates     *template.Template
+	templatesOnce sync.Once
+)
+
+func getTemplates() *template.Template {
+	templatesOnce.Do(func() {
+		templates = template.Must(template.New("").ParseGlob(tmplGlob))
+	})
+	return templates
+}
+
+func slurp(c context.Context, r io.Reader) []byte {
+	x, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Errorf(c, "Error reading reader: %v", err)
+	}
+	return x
+}
+
+// localPart is the part of the address a message was sent to before
+// the @, in lower case.
+func localPart(path string) string {
+	return strings.ToLower(strings.Split(path, "@")[0][len("/_ah/mail/"):])
+}
+
+// IncomingMail receives mail for an address and does whatever the
+// routing table says with it.  Mail for addresses that aren't enabled,
+// or that the filters don't like, goes to the quarantine.
+func IncomingMail(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	addr := localPart(r.URL.Path)
+	g, err := lookupGrant(c, addr)
+	if err != nil {
+		log.Errorf(c, "Can't confirm %q is OK: %v", addr, err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	fullBody := slurp(c, r.Body)
+	inmsg, err := mail.ReadMessage(bytes.NewReader(fullBody))
+	if err != nil {
+		log.Errorf(c, "Error parsing incoming mail: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if now := time.Now(); g == nil || !g.live(now) {
+		reason := "not enabled"
+		if g != nil {
+			reason = "expired " + g.Expires.Format("2006-01-02 15:04 MST")
+		}
+		log.Infof(c, "%q is %v.  Quarantining it.", addr, reason)
+		err := keepMessage(c, quarantineKind, addr, inmsg.Header, fullBody, reason)
+		if err != nil {
+			log.Errorf(c, "Error quarantining mail for %q: %v", addr, err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	rt, err := loadRoutes(c)
+	if err != nil {
+		log.Errorf(c, "Error loading mail routes: %v", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	text := &msgExtractor{}
+	if err := text.parse(c, fullBody); err != nil {
+		log.Infof(c, "Error decoding mail to filter: %v", err)
+	}
+	v, ok := filterMessage(&rt.Filter, inmsg.Header, text.body+"\n"+text.hbody, len(fullBody))
+	if !ok {
+		log.Infof(c, "Quarantining mail for %q: %v", addr, v)
+		err := keepMessage(c, quarantineKind, addr, inmsg.Header, fullBody, "filtered: "+v.String())
+		if err != nil {
+			log.Errorf(c, "Error quarantining mail for %q: %v", addr, err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	action, err := deliver(c, rt, addr, inmsg.Header, fullBody, v.String())
+	switch {
+	case err != nil && action == actionStore:
+		log.Errorf(c, "Error storing mail for %q: %v", addr, err)
+		http.Error(w, err.Error(), 500)
+	case err != nil:
+		log.Errorf(c, "Couldn't send email: %v", err)
+		w.WriteHeader(http.StatusAccepted)
+	case action == actionDrop:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// deliver does what the routing table says with a message for addr
+// and reports which action that was.  Mail no rule matches is
+// dropped.  A non-empty verdict is recorded in filterHeader.
+func deliver(c context.Context, rt *routeTable, addr string, h mail.Header, data []byte, verdict string) (string, error) {
+	rule := rt.match(addr)
+	switch {
+	case rule == nil || rule.Action == actionDrop:
+		log.Infof(c, "Dropping mail for %q", addr)
+		return actionDrop, nil
+	case rule.Action == actionStore:
+		if verdict != "" {
+			data = append([]byte(filterHeader+": "+verdict+"\r\n"), data...)
+		}
+		return actionStore, keepMessage(c, mailboxKind, addr, h, data, "")
+	}
+
+	msgex := &msgExtractor{
+		atts: []platform.Attachment{
+			{Name: "original.eml", Data: data}},
+	}
+	if err := msgex.parse(c, data); err != nil {
+		log.Errorf(c, "Error parsing incoming mail: %v", err)
+	}
+
+	if msgex.body == "" && msgex.hbody == "" {
+		log.Infof(c, "No body found.  Sticking the full text body in.")
+		msgex.parsePlain(c, bytes.NewReader(data))
+	}
+
+	msg := &platform.Message{
+		Sender:      rt.Sender,
+		To:          rule.To,
+		Subject:     rule.Prefix + decodeHeader(h.Get("subject")),
+		Body:        msgex.body,
+		HTMLBody:    msgex.hbody,
+		Attachments: msgex.atts,
+	}
+	if verdict != "" {
+		msg.Headers = mail.Header{filterHeader: {verdict}}
+	}
+	return actionForward, platform.Default.Mail.Send(c, msg)
+}
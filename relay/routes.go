@@ -0,0 +1,205 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"context"
+
+	"platform"
+	"platform/log"
+)
+
+const routesFile = "mailroutes.json"
+
+// Things a rule can do with a message.
+const (
+	actionForward = "forward"
+	actionStore   = "store"
+	actionDrop    = "drop"
+)
+
+// A rule says what to do with mail for matching addresses.
+type rule struct {
+	// Match is a local part ("dustin"), a wildcard ("bob-*") or a
+	// regular expression between slashes ("/^bug-[0-9]+$/").
+	// Addresses are compared in lower case.
+	Match string `json:"match"`
+	// What to do: forward (the default), store or drop.
+	Action string `json:"action,omitempty"`
+	// Where forwarded mail goes.
+	To []string `json:"to,omitempty"`
+	// Put in front of forwarded subjects, e.g. "[bob] ".
+	Prefix string `json:"prefix,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// routeTable maps addresses to rules.  The first rule that matches
+// wins, and mail no rule matches is dropped.
+type routeTable struct {
+	// Who forwarded mail is from.
+	Sender string `json:"sender"`
+	Rules  []rule `json:"rules"`
+	// What mail for live addresses has to get past (see filter.go).
+	Filter filterConfig `json:"filter"`
+}
+
+// storedConfig is a config document uploaded from the admin page.
+type storedConfig struct {
+	Data    []byte `datastore:",noindex"`
+	Updated time.Time
+}
+
+func (r *rule) matches(addr string) bool {
+	switch {
+	case r.re != nil:
+		return r.re.MatchString(addr)
+	case strings.ContainsAny(r.Match, "*?["):
+		ok, _ := path.Match(r.Match, addr)
+		return ok
+	}
+	return r.Match == addr
+}
+
+// match finds the rule for an address, if any.
+func (rt *routeTable) match(addr string) *rule {
+	for i := range rt.Rules {
+		if rt.Rules[i].matches(addr) {
+			return &rt.Rules[i]
+		}
+	}
+	return nil
+}
+
+func parseRoutes(data []byte) (*routeTable, error) {
+	rt := &routeTable{}
+	if err := json.Unmarshal(data, rt); err != nil {
+		return nil, err
+	}
+	if rt.Sender == "" {
+		return nil, fmt.Errorf("no sender configured")
+	}
+
+	for i := range rt.Rules {
+		r := &rt.Rules[i]
+		r.Match = strings.ToLower(r.Match)
+		switch {
+		case r.Match == "":
+			return nil, fmt.Errorf("rule %d has nothing to match", i)
+		case len(r.Match) > 1 && strings.HasPrefix(r.Match, "/") && strings.HasSuffix(r.Match, "/"):
+			re, err := regexp.Compile(r.Match[1 : len(r.Match)-1])
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %v", r.Match, err)
+			}
+			r.re = re
+		default:
+			if _, err := path.Match(r.Match, ""); err != nil {
+				return nil, fmt.Errorf("rule %v: %v", r.Match, err)
+			}
+		}
+
+		if r.Action == "" {
+			r.Action = actionForward
+		}
+		switch r.Action {
+		case actionForward:
+			if len(r.To) == 0 {
+				return nil, fmt.Errorf("rule %v forwards to nobody", r.Match)
+			}
+		case actionStore, actionDrop:
+		default:
+			return nil, fmt.Errorf("rule %v has unknown action %q", r.Match, r.Action)
+		}
+	}
+	if err := rt.Filter.parse(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// rawRoutes returns the routing table in effect: whatever was last
+// uploaded, or the one that shipped with the app.
+func rawRoutes(c context.Context) ([]byte, error) {
+	stored := storedConfig{}
+	err := platform.Default.Blobs.Get(c, "Config", "mailroutes", &stored)
+	switch err {
+	case nil:
+		return stored.Data, nil
+	case platform.ErrNoSuchEntity:
+		return ioutil.ReadFile(routesFile)
+	}
+	return nil, err
+}
+
+func loadRoutes(c context.Context) (*routeTable, error) {
+	data, err := rawRoutes(c)
+	if err != nil {
+		return nil, err
+	}
+	return parseRoutes(data)
+}
+
+// storeRoutes replaces the routing table, refusing one that doesn't
+// parse.
+func storeRoutes(c context.Context, data []byte) error {
+	if _, err := parseRoutes(data); err != nil {
+		return err
+	}
+	return platform.Default.Blobs.Put(c, "Config", "mailroutes",
+		&storedConfig{Data: data, Updated: time.Now()})
+}
+
+// HandleRoutes shows the mail routing table.  POSTing a document
+// replaces it, and the addr parameter shows which rule an address
+// would get.
+func HandleRoutes(w http.ResponseWriter, r *http.Request) {
+	c := platform.NewContext(r)
+
+	var msg string
+	if r.Method == "POST" {
+		data := []byte(r.FormValue("config"))
+		if err := storeRoutes(c, data); err != nil {
+			msg = "Routes not saved: " + err.Error()
+		} else {
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			return
+		}
+	}
+
+	raw, err := rawRoutes(c)
+	if err != nil {
+		http.Error(w, "Error loading mail routes: "+err.Error(), 500)
+		return
+	}
+
+	rt, err := parseRoutes(raw)
+	if err != nil {
+		msg = fmt.Sprintf("Current routes are invalid: %v", err)
+		rt = &routeTable{}
+	}
+
+	addr := strings.ToLower(r.FormValue("addr"))
+	var tested *rule
+	if addr != "" {
+		tested = rt.match(addr)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = getTemplates().ExecuteTemplate(w, "routes.html", struct {
+		Message string
+		Config  string
+		Routes  *routeTable
+		Addr    string
+		Tested  *rule
+	}{msg, string(raw), rt, addr, tested})
+	if err != nil {
+		log.Errorf(c, "Error rendering mail routes: %v", err)
+	}
+}
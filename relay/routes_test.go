@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const testRoutes = `{
+  "sender": "relay@example.com",
+  "rules": [
+    {"match": "spam", "action": "drop"},
+    {"match": "Dustin", "to": ["dustin@example.com"]},
+    {"match": "/^bug-[0-9]+$/", "to": ["bugs@example.com", "dustin@example.com"], "prefix": "[bug] "},
+    {"match": "bob-*", "to": ["bob@example.com"], "prefix": "[bob] "},
+    {"match": "keep?", "action": "store"}
+  ]
+}`
+
+func TestRouteMatch(t *testing.T) {
+	rt, err := parseRoutes([]byte(testRoutes))
+	if err != nil {
+		t.Fatalf("Error parsing routes: %v", err)
+	}
+
+	tests := []struct {
+		addr, match, action string
+	}{
+		{"dustin", "dustin", actionForward},
+		{"spam", "spam", actionDrop},
+		{"bug-42", "/^bug-[0-9]+$/", actionForward},
+		{"bug-x", "", ""},
+		{"bob-shopping", "bob-*", actionForward},
+		{"bob", "", ""},
+		{"keep1", "keep?", actionStore},
+		{"keep12", "", ""},
+		{"nobody", "", ""},
+	}
+	for _, test := range tests {
+		r := rt.match(test.addr)
+		switch {
+		case r == nil && test.match == "":
+		case r == nil:
+			t.Errorf("Expected %v to match %v", test.addr, test.match)
+		case r.Match != test.match || r.Action != test.action:
+			t.Errorf("%v matched %v/%v, want %v/%v",
+				test.addr, r.Match, r.Action, test.match, test.action)
+		}
+	}
+
+	if r := rt.match("bug-7"); len(r.To) != 2 || r.Prefix != "[bug] " {
+		t.Errorf("Unexpected bug rule: %+v", r)
+	}
+}
+
+func TestRouteOrder(t *testing.T) {
+	rt, err := parseRoutes([]byte(`{"sender": "s@example.com", "rules": [
+      {"match": "*", "action": "drop"},
+      {"match": "dustin", "to": ["d@example.com"]}]}`))
+	if err != nil {
+		t.Fatalf("Error parsing routes: %v", err)
+	}
+	if r := rt.match("dustin"); r == nil || r.Action != actionDrop {
+		t.Errorf("Expected the first rule to win, got %+v", r)
+	}
+}
+
+func TestRouteErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, err string
+	}{
+		{"no sender", `{"rules": []}`, "no sender"},
+		{"empty match", `{"sender": "s", "rules": [{"to": ["x"]}]}`, "nothing to match"},
+		{"bad regex", `{"sender": "s", "rules": [{"match": "/a(/", "to": ["x"]}]}`, "missing closing"},
+		{"bad wildcard", `{"sender": "s", "rules": [{"match": "a[", "to": ["x"]}]}`, "syntax error"},
+		{"nowhere", `{"sender": "s", "rules": [{"match": "a"}]}`, "forwards to nobody"},
+		{"bad action", `{"sender": "s", "rules": [{"match": "a", "action": "bounce"}]}`, "unknown action"},
+		{"bad json", `{`, "unexpected end"},
+	}
+	for _, test := range tests {
+		_, err := parseRoutes([]byte(test.doc))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestShippedRoutes(t *testing.T) {
+	data, err := ioutil.ReadFile("../" + routesFile)
+	if err != nil {
+		t.Fatalf("Error reading shipped routes: %v", err)
+	}
+	rt, err := parseRoutes(data)
+	if err != nil {
+		t.Fatalf("Shipped routes are invalid: %v", err)
+	}
+	if r := rt.match("anything"); r == nil || r.Action != actionForward {
+		t.Errorf("Expected shipped routes to forward everything, got %+v", r)
+	}
+}
@@ -36,6 +36,8 @@ var shouldResume = flag.Bool("resume", false,
 	"automatically resume from last position")
 var readTimeout = flag.Duration("readTimeout", time.Second*30,
 	"HTTP read timeout")
+var token = flag.String("token", "",
+	"Device token (name:secret) to authenticate with")
 
 func maybefatal(err error, msg string, args ...interface{}) {
 	if err != nil {
@@ -160,7 +162,20 @@ func parseTime(in string) (time.Time, error) {
 		np[3], np[4], np[5], nsec, time.Local), nil
 }
 
-func storeItems(cs []change) error {
+// inputResult is the server's accounting of a batch.
+type inputResult struct {
+	Accepted []int
+	Rejected []struct {
+		Index int
+		Error string
+		Retry bool
+	}
+	Duplicates int
+}
+
+// storeItems sends a batch and returns the items that should be sent
+// again.  Items the server refused outright are logged and dropped.
+func storeItems(cs []change) ([]change, error) {
 	sns := []string{}
 	tss := []string{}
 	rs := []string{}
@@ -187,24 +202,59 @@ func storeItems(cs []change) error {
 		log.Printf("Taking longer than %v to send data",
 			*readTimeout)
 	})
+	defer wd.Stop()
 
-	resp, err := httpClient.PostForm(baseURL, params)
+	req, err := http.NewRequest("POST", baseURL, strings.NewReader(params.Encode()))
 	if err != nil {
-		wd.Stop()
-		return err
+		return cs, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 || resp.StatusCode < 200 {
-		wd.Stop()
-		emsg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
-		return fmt.Errorf("HTTP Error:  %v\n%s", resp.Status, emsg)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
 	}
 
-	if !wd.Stop() {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return cs, err
+	}
+	defer resp.Body.Close()
+
+	if time.Since(start) > *readTimeout {
 		log.Printf("Finished long request in %v",
 			time.Since(start))
 	}
-	return nil
+
+	res := inputResult{}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") ||
+		json.NewDecoder(resp.Body).Decode(&res) != nil {
+		if resp.StatusCode >= 300 || resp.StatusCode < 200 {
+			emsg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			return cs, fmt.Errorf("HTTP Error:  %v\n%s", resp.Status, emsg)
+		}
+		return nil, nil
+	}
+
+	if res.Duplicates > 0 {
+		log.Printf("Server already had %v of %v items", res.Duplicates, len(cs))
+	}
+	return retryable(cs, res), nil
+}
+
+// retryable returns the changes the server asked to have sent again.
+func retryable(cs []change, res inputResult) []change {
+	var rv []change
+	for _, r := range res.Rejected {
+		if r.Index < 0 || r.Index >= len(cs) {
+			continue
+		}
+		if r.Retry {
+			rv = append(rv, cs[r.Index])
+		} else {
+			log.Printf("Server rejected %v (seq %v): %v",
+				cs[r.Index].ID, cs[r.Index].Seq, r.Error)
+		}
+	}
+	return rv
 }
 
 func sendData(ch <-chan change) {
@@ -230,22 +280,23 @@ func sendData(ch <-chan change) {
 
 		log.Printf("Transmitting %v items up to %v", len(items), latest)
 
-		done := false
 		retries := 5
-		for !done {
-			err := storeItems(items)
-			if err == nil {
-				done = true
+		for len(items) > 0 {
+			left, err := storeItems(items)
+			if err == nil && len(left) == 0 {
+				break
+			}
+			retries--
+			if retries <= 0 {
+				log.Fatalf("Too much failure")
+			}
+			if err != nil {
+				log.Printf("Failed to store items, retrying: %v", err)
 			} else {
-				retries--
-				if retries > 0 {
-					log.Printf("Failed to store item, retrying: %v",
-						err)
-					time.Sleep(time.Second)
-				} else {
-					log.Fatalf("Too much failure")
-				}
+				log.Printf("Retrying %v of %v items", len(left), len(items))
 			}
+			items = left
+			time.Sleep(time.Second)
 		}
 	}
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	cs := []change{{Seq: 1}, {Seq: 2}, {Seq: 3}, {Seq: 4}}
+
+	res := inputResult{}
+	err := json.Unmarshal([]byte(`{"accepted": [0],
+		"rejected": [{"index": 1, "error": "bad", "retry": false},
+		             {"index": 2, "error": "queue", "retry": true},
+		             {"index": 3, "error": "queue", "retry": true},
+		             {"index": 9, "error": "bogus", "retry": true}]}`), &res)
+	if err != nil {
+		t.Fatalf("Error decoding result: %v", err)
+	}
+
+	got := retryable(cs, res)
+	if len(got) != 2 || got[0].Seq != 3 || got[1].Seq != 4 {
+		t.Errorf("Expected seqs 3 and 4 to be retried, got %+v", got)
+	}
+}
@@ -0,0 +1,84 @@
+// Command houselint checks house configurations for mistakes.
+//
+// Usage:
+//
+//	houselint [-serials file] config...
+//
+// Each config may be a file or a URL.  Problems that would keep a
+// config from being used are reported as errors; things that are
+// merely suspicious (overlapping rooms, rooms that are never drawn)
+// as warnings.  With -serials, a file listing one serial number per
+// line (e.g. everything that reports readings), it also reports
+// serials no room uses and rooms whose serial isn't listed.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"house"
+	"platform"
+)
+
+var serialsFile = flag.String("serials", "", "File listing known serial numbers")
+
+func readSerials(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rv []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if sn := strings.TrimSpace(s.Text()); sn != "" && !strings.HasPrefix(sn, "#") {
+			rv = append(rv, sn)
+		}
+	}
+	return rv, s.Err()
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %v [-serials file] config...\n", os.Args[0])
+		os.Exit(64)
+	}
+
+	var known []string
+	if *serialsFile != "" {
+		var err error
+		if known, err = readSerials(*serialsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading serials: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	platform.Use(platform.Local(os.TempDir(), "localhost", nil))
+	c := context.Background()
+
+	status := 0
+	for _, src := range flag.Args() {
+		hc, err := house.LoadConfig(c, src)
+		if errs, ok := err.(house.ConfigErrors); ok {
+			for _, e := range errs {
+				fmt.Printf("%v: error: %v\n", src, e)
+			}
+			status = 1
+		} else if err != nil {
+			fmt.Printf("%v: error: %v\n", src, err)
+			status = 1
+			continue
+		}
+		for _, w := range hc.Lint(known) {
+			fmt.Printf("%v: warning: %v\n", src, w)
+		}
+	}
+	os.Exit(status)
+}
@@ -15,6 +15,7 @@ func init() {
 	http.HandleFunc("/~dustin/", dustin.ServePage)
 	http.HandleFunc("/cron/update/feeds/", dustin.UpdateFeeds)
 	http.HandleFunc("/cron/sensors/check", dustin.CheckSensors)
+	http.HandleFunc("/admin/sensors", dustin.SensorStatus)
 
 	registerWarmup(dustin.UpdateFeeds)
 }
@@ -5,8 +5,8 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"platform"
+	"platform/log"
 )
 
 func init() {
@@ -14,7 +14,7 @@ func init() {
 }
 
 func handleGitmirror(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := platform.NewContext(r)
 	n, err := io.Copy(ioutil.Discard, r.Body)
 	log.Infof(c, "Read %v bytes with err=%v", n, err)
 	w.WriteHeader(201)
@@ -0,0 +1,23 @@
+package westspy
+
+import (
+	"net/http"
+
+	"house"
+)
+
+func init() {
+	http.HandleFunc("/house/", house.Server)
+	http.HandleFunc("/house/house.svg", house.ServeSVG)
+	http.HandleFunc("/house/dashboard", house.HandleDashboard)
+	http.HandleFunc("/house/input/", house.HandleInput)
+	http.HandleFunc("/house/history/", house.HandleHistory)
+	http.HandleFunc("/house/rollups/", house.HandleRollups)
+	http.HandleFunc("/house/api/current", house.HandleAPICurrent)
+	http.HandleFunc("/house/api/readings", house.HandleAPIReadings)
+	http.HandleFunc("/cron/house/consume/", house.ConsumeInput)
+	http.HandleFunc("/admin/house/devices", house.HandleDevices)
+	http.HandleFunc("/admin/house/config", house.HandleConfig)
+
+	registerWarmup(house.Warmup)
+}
@@ -0,0 +1,16 @@
+package westspy
+
+import (
+	"net/http"
+
+	"relay"
+)
+
+func init() {
+	http.HandleFunc("/_ah/mail/", relay.IncomingMail)
+	http.HandleFunc("/admin/enableMail", relay.EnableMail)
+	http.HandleFunc("/admin/mailRoutes", relay.HandleRoutes)
+	http.HandleFunc("/admin/mailbox", relay.HandleMailbox)
+	http.HandleFunc("/admin/mailQuarantine", relay.HandleQuarantine)
+	http.HandleFunc("/cron/mail/purge", relay.PurgeQuarantine)
+}
@@ -12,8 +12,8 @@ import (
 
 	"context"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
+	"platform"
+	"platform/log"
 )
 
 const (
@@ -48,7 +48,7 @@ func remote(r *http.Request) string {
 }
 
 func redirect(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := platform.NewContext(r)
 	exp := time.Now().Add(expDuration).Unix()
 	awsID := os.Getenv("AWS_ACCESS_KEY_ID")
 	awsKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
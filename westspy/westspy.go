@@ -1,3 +1,4 @@
+// Package westspy registers the site's handlers on http.DefaultServeMux.
 package westspy
 
 import (